@@ -2,23 +2,68 @@ package main
 
 import (
 	"chainlink_exporter/abi"
-	"context"
 	"encoding/hex"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"go.uber.org/zap"
+	"math/big"
 	"sync"
-	"time"
 )
 
 type (
+	// pendingRequest is a request with the details that are resolved once, when it is first seen. It is persisted
+	// as the fields of the request alongside the resolved fields.
+	pendingRequest struct {
+		*abi.OracleOracleRequest
+		// JobName is the name of the job of the request. It is empty if the job was unknown.
+		JobName string
+		// Time is the timestamp of the request block. It is 0 if it couldn't be fetched.
+		Time uint64
+	}
+
+	// outcome is a fulfillment, cancellation or miss that hasn't been recorded in the metrics yet
+	outcome struct {
+		req *pendingRequest
+		// res is only set for fulfillments
+		res *abi.AggregatorChainlinkFulfilled
+		// cancel is only set for cancellations
+		cancel *abi.OracleCancelOracleRequest
+		// height is the block in which the request was fulfilled, cancelled or declared missed
+		height uint64
+		// responded is the timestamp of the fulfillment block. It is 0 if it couldn't be fetched.
+		responded uint64
+	}
+
+	// AggregatorMonitor tracks the requests of a single requester. For requesters that aren't aggregators,
+	// aggregator is nil and fulfillments are passed in by the Monitor's FulfillmentScanner.
 	AggregatorMonitor struct {
 		aggregator *abi.Aggregator
 		address    common.Address
 
-		pendingJobs map[string]*abi.OracleOracleRequest
+		pendingJobs map[string]*pendingRequest
+		// unconfirmedOutcomes are outcomes that are not yet buried by the configured number of confirmations
+		unconfirmedOutcomes map[string]*outcome
 
-		seenRequestIDs map[string]bool
+		// seenRequestIDs are the heights of the seen requests by request ID
+		seenRequestIDs map[string]uint64
+		// unsavedRequestIDs are seen request IDs that haven't been written to the store yet
+		unsavedRequestIDs []string
+		// removedRequestIDs are request IDs that were reverted or pruned and need to be removed from the store
+		removedRequestIDs []string
+
+		// round is the latest answer of the aggregator
+		round roundState
+		// responses are the answers our oracle submitted to rounds that aren't answered yet, by round ID
+		responses map[uint64]*big.Int
+		// correlatedRound is the latest round our response was compared to the answer of
+		correlatedRound *big.Int
+
+		// config is the configuration of the aggregator. It is nil until it was fetched.
+		config *aggregatorConfig
+		// responders are the responses to the recent rounds in the order they were received
+		responders map[uint64][]responder
+		// rankedRound is the latest round our response was ranked in
+		rankedRound *big.Int
 
 		monitor *Monitor
 		lock    sync.Mutex
@@ -27,91 +72,344 @@ type (
 
 func NewAggregatorMonitor(agg *abi.Aggregator, addr common.Address, m *Monitor) *AggregatorMonitor {
 	return &AggregatorMonitor{
-		aggregator:     agg,
-		pendingJobs:    map[string]*abi.OracleOracleRequest{},
-		seenRequestIDs: map[string]bool{},
-		monitor:        m,
-		address:        addr,
+		aggregator:          agg,
+		pendingJobs:         map[string]*pendingRequest{},
+		unconfirmedOutcomes: map[string]*outcome{},
+		seenRequestIDs:      map[string]uint64{},
+		responses:           map[uint64]*big.Int{},
+		responders:          map[uint64][]responder{},
+		monitor:             m,
+		address:             addr,
 	}
 }
 
-func (a *AggregatorMonitor) Monitor() {
-	for {
-		zap.L().Debug("Starting aggregator routine", zap.String("address", a.address.String()))
-		func() {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-			defer cancel()
-
-			resChan := make(chan *abi.AggregatorChainlinkFulfilled)
-			sub, err := a.aggregator.WatchChainlinkFulfilled(&bind.WatchOpts{Context: ctx}, resChan, nil)
-			if err != nil {
-				zap.L().Error("failed to watch aggregator fulfillment", zap.Error(err), zap.String("address", a.address.String()))
-				return
-			}
-
-			for {
-				select {
-				case err = <-sub.Err():
-					zap.L().Error("aggregator fulfillment subscription errored", zap.Error(err), zap.String("address", a.address.String()))
-					return
-				case res, has := <-resChan:
-					if !has {
-						zap.L().Error("head subscription closed", zap.Error(err), zap.String("address", a.address.String()))
-						return
-					}
-					a.handleFulfillment(res)
-				}
-			}
-		}()
-
-		zap.L().Warn("aggregator routine died. restarting in 5sec", zap.String("address", a.address.String()))
-		time.Sleep(5 * time.Second)
+// handleFulfillmentLog decodes a ChainlinkFulfilled log of the aggregator passed in by the LogDispatcher.
+func (a *AggregatorMonitor) handleFulfillmentLog(l types.Log) error {
+	res, err := a.aggregator.ParseChainlinkFulfilled(l)
+	if err != nil {
+		return err
 	}
+
+	a.handleFulfillment(res)
+	return nil
 }
 
-func (a *AggregatorMonitor) HandleNewBlock(height uint64) {
+// HandleNewBlock declares pending requests whose deadline passed as missed and records confirmed outcomes.
+func (a *AggregatorMonitor) HandleNewBlock(height uint64, timestamp uint64) {
+	// Recording an outcome can involve RPC calls, so it's done without holding the lock
+	for _, o := range a.confirmOutcomes(height, timestamp) {
+		switch {
+		case o.res != nil:
+			a.monitor.HandleFulfillment(o.res, o.req, o.responded)
+		case o.cancel != nil:
+			a.monitor.HandleCancellation(o.req)
+		default:
+			a.monitor.HandleMiss(o.req)
+		}
+	}
+}
+
+// confirmOutcomes declares pending requests whose deadline passed as missed and returns the outcomes that are
+// confirmed at the given height.
+func (a *AggregatorMonitor) confirmOutcomes(height uint64, timestamp uint64) []*outcome {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
 	for reqID, n := range a.pendingJobs {
-		delta := int(height) - int(n.Raw.BlockNumber)
-		// todo make dynamic
-		if delta > 15 {
+		if a.deadlinePassed(n, height, timestamp) {
 			zap.L().Info("job fulfillment slot missed", zap.Uint64("height", n.Raw.BlockNumber),
 				zap.String("requester", n.Requester.String()), zap.Binary("request_id", n.RequestId[:]),
 				zap.String("spec_id", sanitizeSpecID(n.SpecId)))
 			delete(a.pendingJobs, reqID)
-			a.monitor.HandleMiss(n)
+			a.unconfirmedOutcomes[reqID] = &outcome{req: n, height: height}
+		}
+	}
+
+	var confirmed []*outcome
+	for reqID, o := range a.unconfirmedOutcomes {
+		depth := a.monitor.cfg.Confirmations
+		if o.res == nil && o.cancel == nil {
+			// Expired requests can be cancelled at any time, a late cancellation must not be counted as a miss
+			depth += a.monitor.cfg.CancelWindowBlocks
+		}
+		if o.height+depth > height {
+			continue
+		}
+
+		delete(a.unconfirmedOutcomes, reqID)
+		confirmed = append(confirmed, o)
+	}
+	a.pruneSeen(height)
+
+	return confirmed
+}
+
+// pruneSeen forgets the request IDs of requests whose outcome is recorded once they are older than the tracked
+// blocks. Their logs can't be delivered again since deeper reorgs aren't handled. Must be called with the lock held.
+func (a *AggregatorMonitor) pruneSeen(height uint64) {
+	for reqID, requested := range a.seenRequestIDs {
+		if requested+trackedBlocks >= height {
+			continue
+		}
+		if _, ok := a.pendingJobs[reqID]; ok {
+			continue
+		}
+		if _, ok := a.unconfirmedOutcomes[reqID]; ok {
+			continue
+		}
+		a.forget(reqID)
+	}
+}
+
+// deadlinePassed checks whether a request can no longer be fulfilled in time at the given block.
+// Requests of specs with a configured block window expire after that many blocks. The response window of the job
+// is used if no block window is configured. All other requests expire once the block timestamp passes their cancel
+// expiration.
+func (a *AggregatorMonitor) deadlinePassed(req *pendingRequest, height uint64, timestamp uint64) bool {
+	specID := sanitizeSpecID(req.SpecId)
+	window, ok := a.monitor.cfg.DeadlineBlocks[specID]
+	if !ok {
+		if job, found := a.monitor.cfg.Jobs.Job(specID); found && job.ResponseWindow > 0 {
+			window, ok = job.ResponseWindow, true
+		}
+	}
+	if ok {
+		return height > req.Raw.BlockNumber+window
+	}
+	if req.CancelExpiration != nil && req.CancelExpiration.Sign() > 0 {
+		return req.CancelExpiration.Cmp(new(big.Int).SetUint64(timestamp)) < 0
+	}
+
+	return height > req.Raw.BlockNumber+DefaultDeadlineBlocks
+}
+
+// Rollback reverts all misses that were declared after the given block because the chain was reorganized.
+// Reverted cancellations and fulfillments of aggregators are handled by the removed logs of their subscriptions.
+// Scanned fulfillments don't have removed logs and are reverted here as well.
+func (a *AggregatorMonitor) Rollback(ancestor uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for reqID, o := range a.unconfirmedOutcomes {
+		if o.height <= ancestor || o.cancel != nil || (o.res != nil && a.aggregator != nil) {
+			continue
+		}
+
+		zap.L().Info("outcome reverted by reorg", zap.Uint64("height", o.height),
+			zap.String("requester", o.req.Requester.String()), zap.Binary("request_id", o.req.RequestId[:]),
+			zap.String("spec_id", sanitizeSpecID(o.req.SpecId)))
+		delete(a.unconfirmedOutcomes, reqID)
+		a.pendingJobs[reqID] = o.req
+	}
+}
+
+// Rewind forgets everything that happened after the given block so the blocks can be processed again.
+// It is used instead of Rollback when logs are polled, since polled logs are never reported as removed.
+func (a *AggregatorMonitor) Rewind(ancestor uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for reqID, req := range a.pendingJobs {
+		if req.Raw.BlockNumber > ancestor {
+			delete(a.pendingJobs, reqID)
+			a.forget(reqID)
+		}
+	}
+	for reqID, o := range a.unconfirmedOutcomes {
+		switch {
+		case o.req.Raw.BlockNumber > ancestor:
+			delete(a.unconfirmedOutcomes, reqID)
+			a.forget(reqID)
+		case o.height > ancestor:
+			zap.L().Info("outcome reverted by reorg", zap.Uint64("height", o.height),
+				zap.String("requester", o.req.Requester.String()), zap.Binary("request_id", o.req.RequestId[:]),
+				zap.String("spec_id", sanitizeSpecID(o.req.SpecId)))
+			delete(a.unconfirmedOutcomes, reqID)
+			a.pendingJobs[reqID] = o.req
+		}
+	}
+}
+
+// forget removes a request ID from the seen request IDs. Must be called with the lock held.
+func (a *AggregatorMonitor) forget(reqID string) {
+	if _, ok := a.seenRequestIDs[reqID]; ok {
+		delete(a.seenRequestIDs, reqID)
+		if a.monitor.store != nil {
+			a.removedRequestIDs = append(a.removedRequestIDs, reqID)
 		}
 	}
 }
 
-func (a *AggregatorMonitor) handleRequest(res *abi.OracleOracleRequest) {
+// handleRequest tracks a new request. requested is the timestamp of the request block.
+func (a *AggregatorMonitor) handleRequest(res *abi.OracleOracleRequest, requested uint64) {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 	requestIDString := hex.EncodeToString(res.RequestId[:])
+	if res.Raw.Removed {
+		zap.L().Info("request reverted by reorg", zap.Uint64("height", res.Raw.BlockNumber),
+			zap.String("requester", res.Requester.String()), zap.Binary("request_id", res.RequestId[:]),
+			zap.String("spec_id", sanitizeSpecID(res.SpecId)))
+		delete(a.pendingJobs, requestIDString)
+		delete(a.unconfirmedOutcomes, requestIDString)
+		a.forget(requestIDString)
+		return
+	}
+
 	if _, exists := a.seenRequestIDs[requestIDString]; exists {
 		zap.L().Info("request dropped; already seen same reqID", zap.Uint64("height", res.Raw.BlockNumber),
 			zap.String("requester", res.Requester.String()), zap.Binary("request_id", res.RequestId[:]),
 			zap.String("spec_id", sanitizeSpecID(res.SpecId)), zap.Uint64("request_height", res.Raw.BlockNumber))
 		return
 	} else {
-		a.seenRequestIDs[requestIDString] = true
+		a.seenRequestIDs[requestIDString] = res.Raw.BlockNumber
+		if a.monitor.store != nil {
+			a.unsavedRequestIDs = append(a.unsavedRequestIDs, requestIDString)
+		}
 	}
 
-	a.pendingJobs[requestIDString] = res
+	job, _ := a.monitor.cfg.Jobs.Job(sanitizeSpecID(res.SpecId))
+	a.pendingJobs[requestIDString] = &pendingRequest{OracleOracleRequest: res, JobName: job.Name, Time: requested}
 }
 
 func (a *AggregatorMonitor) handleFulfillment(res *abi.AggregatorChainlinkFulfilled) {
+	// The timestamp is fetched now so recording the outcome doesn't need an RPC call
+	var responded uint64
+	if !res.Raw.Removed {
+		var err error
+		if responded, err = a.monitor.blockTimes.Time(res.Raw.BlockHash); err != nil {
+			zap.L().Warn("failed to fetch fulfillment block timestamp", zap.Error(err),
+				zap.Uint64("height", res.Raw.BlockNumber), zap.String("tx", res.Raw.TxHash.String()))
+		}
+	}
+
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
-	if job, ok := a.pendingJobs[hex.EncodeToString(res.Id[:])]; ok {
+	requestIDString := hex.EncodeToString(res.Id[:])
+	if res.Raw.Removed {
+		if o, ok := a.unconfirmedOutcomes[requestIDString]; ok && o.res != nil && o.res.Raw.BlockHash == res.Raw.BlockHash {
+			zap.L().Info("fulfillment reverted by reorg", zap.Uint64("height", res.Raw.BlockNumber),
+				zap.String("requester", o.req.Requester.String()), zap.Binary("request_id", o.req.RequestId[:]),
+				zap.String("spec_id", sanitizeSpecID(o.req.SpecId)))
+			delete(a.unconfirmedOutcomes, requestIDString)
+			a.pendingJobs[requestIDString] = o.req
+		}
+		return
+	}
+
+	if job, ok := a.pendingJobs[requestIDString]; ok {
 		zap.L().Info("job fulfilled", zap.Uint64("height", res.Raw.BlockNumber),
 			zap.String("requester", job.Requester.String()), zap.Binary("request_id", job.RequestId[:]),
 			zap.String("spec_id", sanitizeSpecID(job.SpecId)), zap.Uint64("request_height", job.Raw.BlockNumber))
-		delete(a.pendingJobs, hex.EncodeToString(res.Id[:]))
+		delete(a.pendingJobs, requestIDString)
 
-		a.monitor.HandleFulfillment(res, job)
+		a.unconfirmedOutcomes[requestIDString] = &outcome{req: job, res: res, height: res.Raw.BlockNumber, responded: responded}
+	} else if o, ok := a.unconfirmedOutcomes[requestIDString]; ok && o.res == nil && o.cancel == nil && res.Raw.BlockNumber <= o.height {
+		// The miss was declared on a chain that didn't contain this fulfillment yet
+		zap.L().Info("miss reverted; job fulfilled", zap.Uint64("height", res.Raw.BlockNumber),
+			zap.String("requester", o.req.Requester.String()), zap.Binary("request_id", o.req.RequestId[:]),
+			zap.String("spec_id", sanitizeSpecID(o.req.SpecId)), zap.Uint64("request_height", o.req.Raw.BlockNumber))
+		o.res = res
+		o.height = res.Raw.BlockNumber
+		o.responded = responded
 	}
 }
+
+// handleCancellation records the cancellation of a pending or missed request. It returns false if the request
+// doesn't belong to this requester or its outcome has already been recorded.
+func (a *AggregatorMonitor) handleCancellation(cancel *abi.OracleCancelOracleRequest) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	requestIDString := hex.EncodeToString(cancel.RequestId[:])
+	if cancel.Raw.Removed {
+		o, ok := a.unconfirmedOutcomes[requestIDString]
+		if !ok || o.cancel == nil || o.cancel.Raw.BlockHash != cancel.Raw.BlockHash {
+			return false
+		}
+		zap.L().Info("cancellation reverted by reorg", zap.Uint64("height", cancel.Raw.BlockNumber),
+			zap.String("requester", o.req.Requester.String()), zap.Binary("request_id", o.req.RequestId[:]),
+			zap.String("spec_id", sanitizeSpecID(o.req.SpecId)))
+		delete(a.unconfirmedOutcomes, requestIDString)
+		a.pendingJobs[requestIDString] = o.req
+		return true
+	}
+
+	req, ok := a.pendingJobs[requestIDString]
+	if ok {
+		delete(a.pendingJobs, requestIDString)
+	} else if o, ok := a.unconfirmedOutcomes[requestIDString]; ok && o.res == nil && o.cancel == nil {
+		// The request was declared missed but the miss isn't recorded yet
+		req = o.req
+	} else {
+		return false
+	}
+
+	zap.L().Info("job cancelled", zap.Uint64("height", cancel.Raw.BlockNumber),
+		zap.String("requester", req.Requester.String()), zap.Binary("request_id", req.RequestId[:]),
+		zap.String("spec_id", sanitizeSpecID(req.SpecId)), zap.Uint64("request_height", req.Raw.BlockNumber))
+	a.unconfirmedOutcomes[requestIDString] = &outcome{req: req, cancel: cancel, height: cancel.Raw.BlockNumber}
+
+	return true
+}
+
+// restore loads the persisted pending requests and the heights of the seen requests.
+func (a *AggregatorMonitor) restore(pending []*pendingRequest, seen map[string]uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for id, height := range seen {
+		a.seenRequestIDs[id] = height
+	}
+	for _, req := range pending {
+		requestIDString := hex.EncodeToString(req.RequestId[:])
+		a.seenRequestIDs[requestIDString] = req.Raw.BlockNumber
+		a.pendingJobs[requestIDString] = req
+	}
+}
+
+// unconfirmed returns the number of unconfirmed fulfillments, cancellations and misses.
+func (a *AggregatorMonitor) unconfirmed() (fulfilled int, cancelled int, missed int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, o := range a.unconfirmedOutcomes {
+		switch {
+		case o.res != nil:
+			fulfilled++
+		case o.cancel != nil:
+			cancelled++
+		default:
+			missed++
+		}
+	}
+
+	return fulfilled, cancelled, missed
+}
+
+// checkpoint returns the pending requests as well as the requests seen and the request IDs removed since the last
+// checkpoint. Seen requests are returned with their height by request ID. Requests with unconfirmed outcomes are
+// reported as pending since their outcome isn't part of the metrics yet.
+func (a *AggregatorMonitor) checkpoint() (pending []*pendingRequest, seen map[string]uint64, removed []string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	pending = make([]*pendingRequest, 0, len(a.pendingJobs)+len(a.unconfirmedOutcomes))
+	for _, req := range a.pendingJobs {
+		pending = append(pending, req)
+	}
+	for _, o := range a.unconfirmedOutcomes {
+		pending = append(pending, o.req)
+	}
+
+	seen = make(map[string]uint64, len(a.unsavedRequestIDs))
+	for _, id := range a.unsavedRequestIDs {
+		if height, ok := a.seenRequestIDs[id]; ok {
+			seen[id] = height
+		}
+	}
+	removed = a.removedRequestIDs
+	a.unsavedRequestIDs, a.removedRequestIDs = nil, nil
+
+	return pending, seen, removed
+}
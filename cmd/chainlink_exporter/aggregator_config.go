@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"go.uber.org/zap"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// configPollInterval is the interval the configuration of the aggregators is checked for changes in
+	configPollInterval = 5 * time.Minute
+)
+
+type (
+	// aggregatorConfig is the configuration of an aggregator as set by its owner
+	aggregatorConfig struct {
+		oracles          []common.Address
+		jobIDs           [][32]byte
+		minimumResponses uint64
+		paymentAmount    *big.Int
+	}
+)
+
+// fetchConfig reads the oracles, their job IDs, the minimum number of responses and the payment of the aggregator.
+func (a *AggregatorMonitor) fetchConfig() (*aggregatorConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	opts := &bind.CallOpts{Context: ctx}
+
+	minimumResponses, err := a.aggregator.MinimumResponses(opts)
+	if err != nil {
+		return nil, err
+	}
+	paymentAmount, err := a.aggregator.PaymentAmount(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &aggregatorConfig{
+		minimumResponses: minimumResponses.Uint64(),
+		paymentAmount:    paymentAmount,
+	}
+
+	// The length of the oracle list isn't exposed. Reading past its end fails.
+	for i := int64(0); ; i++ {
+		oracle, err := a.aggregator.Oracles(opts, big.NewInt(i))
+		if err != nil && i > 0 {
+			// How the call fails depends on the node and the compiler version of the aggregator. A node that fails
+			// altogether is told apart by reading the previous oracle again.
+			if _, prevErr := a.aggregator.Oracles(opts, big.NewInt(i-1)); prevErr == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobID, err := a.aggregator.JobIds(opts, big.NewInt(i))
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.oracles = append(cfg.oracles, oracle)
+		cfg.jobIDs = append(cfg.jobIDs, jobID)
+	}
+
+	return cfg, nil
+}
+
+// refreshConfig fetches the configuration of the aggregator and reports changes that affect our oracle.
+func (a *AggregatorMonitor) refreshConfig() {
+	cfg, err := a.fetchConfig()
+	if err != nil {
+		zap.L().Warn("failed to fetch aggregator config", zap.Error(err), zap.String("address", a.address.String()))
+		return
+	}
+
+	a.lock.Lock()
+	old := a.config
+	a.config = cfg
+	a.lock.Unlock()
+
+	a.monitor.updateConfigMetrics(a.address, old, cfg)
+	if old != nil {
+		a.reportConfigChanges(old, cfg)
+	}
+}
+
+// reportConfigChanges logs and counts the removal of our oracle from the aggregator as well as changes of
+// its job IDs and its payment.
+func (a *AggregatorMonitor) reportConfigChanges(old, cfg *aggregatorConfig) {
+	logger := zap.L().With(zap.String("address", a.address.String()))
+	changes := a.monitor.configChangeCounter
+
+	oldJobs, jobs := old.jobsOf(a.monitor.addr), cfg.jobsOf(a.monitor.addr)
+	switch {
+	case len(oldJobs) > 0 && len(jobs) == 0:
+		logger.Error("oracle removed from aggregator", zap.Strings("job_ids", oldJobs))
+		changes.WithLabelValues(a.address.String(), "oracle_removed").Inc()
+	case len(oldJobs) == 0 && len(jobs) > 0:
+		logger.Info("oracle added to aggregator", zap.Strings("job_ids", jobs))
+	case strings.Join(oldJobs, ",") != strings.Join(jobs, ","):
+		logger.Warn("job ids of the oracle changed", zap.Strings("old", oldJobs), zap.Strings("new", jobs))
+		changes.WithLabelValues(a.address.String(), "job_id").Inc()
+	}
+
+	if len(oldJobs) > 0 && old.paymentAmount.Cmp(cfg.paymentAmount) != 0 {
+		logger.Warn("aggregator payment changed", zap.String("old", old.paymentAmount.String()),
+			zap.String("new", cfg.paymentAmount.String()))
+		changes.WithLabelValues(a.address.String(), "payment").Inc()
+	}
+	if old.minimumResponses != cfg.minimumResponses {
+		logger.Info("aggregator minimum responses changed", zap.Uint64("old", old.minimumResponses),
+			zap.Uint64("new", cfg.minimumResponses))
+	}
+}
+
+// jobsOf returns the sorted job IDs of an oracle.
+func (c *aggregatorConfig) jobsOf(oracle common.Address) []string {
+	var jobs []string
+	for i, o := range c.oracles {
+		if o == oracle {
+			jobs = append(jobs, sanitizeSpecID(c.jobIDs[i]))
+		}
+	}
+	sort.Strings(jobs)
+
+	return jobs
+}
+
+// configRoutine periodically checks the configuration of all aggregators for changes.
+func (m *Monitor) configRoutine() {
+	zap.L().Info("Starting config routine")
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, monitor := range m.aggregatorMonitors() {
+				if monitor.aggregator != nil {
+					monitor.refreshConfig()
+				}
+			}
+		}
+	}
+}
+
+// updateConfigMetrics exports the configuration of an aggregator and removes the oracles that left it.
+func (m *Monitor) updateConfigMetrics(address common.Address, old, cfg *aggregatorConfig) {
+	aggregator := address.String()
+
+	payment := new(big.Float).Quo(new(big.Float).SetInt(cfg.paymentAmount), big.NewFloat(params.Ether))
+	paymentLink, _ := payment.Float64()
+	m.paymentGauge.WithLabelValues(aggregator).Set(paymentLink)
+	m.minimumResponsesGauge.WithLabelValues(aggregator).Set(float64(cfg.minimumResponses))
+	m.oraclesGauge.WithLabelValues(aggregator).Set(float64(len(cfg.oracles)))
+
+	current := map[[2]string]bool{}
+	for i, oracle := range cfg.oracles {
+		labels := [2]string{oracle.String(), sanitizeSpecID(cfg.jobIDs[i])}
+		current[labels] = true
+		m.oracleInfoGauge.WithLabelValues(aggregator, labels[0], labels[1]).Set(1)
+	}
+	if old == nil {
+		return
+	}
+	for i, oracle := range old.oracles {
+		labels := [2]string{oracle.String(), sanitizeSpecID(old.jobIDs[i])}
+		if !current[labels] {
+			m.oracleInfoGauge.DeleteLabelValues(aggregator, labels[0], labels[1])
+		}
+	}
+}
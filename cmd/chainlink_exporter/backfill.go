@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"go.uber.org/zap"
+	"math/big"
+	"time"
+)
+
+const (
+	// backfillBatchSize is the maximum number of blocks queried per eth_getLogs call.
+	backfillBatchSize = 5000
+	// backfillRetryDelay is the delay before a failed backfill query is retried. It doubles up to
+	// backfillMaxRetryDelay with every failure.
+	backfillRetryDelay    = 5 * time.Second
+	backfillMaxRetryDelay = 5 * time.Minute
+)
+
+// backfill replays OracleRequest, CancelOracleRequest and dispatched events of the last cfg.BackfillBlocks blocks
+// or, if a checkpoint height is given, of all blocks after the checkpoint. It runs alongside the live subscriptions.
+// Both sides are deduplicated by request ID so events that are seen by the backfill as well as by a subscription
+// are only counted once. Failed queries are retried until they succeed since no misses are declared before the
+// backfill is finished.
+func (m *Monitor) backfill(checkpoint *uint64) {
+	defer m.backfilling.Store(false)
+
+	var head uint64
+	retryBackfill("head", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+		defer cancel()
+		header, err := m.client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return err
+		}
+		head = header.Number.Uint64()
+		return nil
+	})
+
+	m.historicalHeight.Store(head)
+	from := uint64(0)
+	if head > m.cfg.BackfillBlocks {
+		from = head - m.cfg.BackfillBlocks
+	}
+	if checkpoint != nil {
+		from = *checkpoint + 1
+	}
+	// Fulfillments of non-aggregator requesters are scanned once the backfill is finished. The genesis block has
+	// no transactions, so a backfill from block 0 is scanned from block 1.
+	scanned := from
+	if from > 0 {
+		scanned = from - 1
+	}
+	m.scanner.Seek(scanned)
+	zap.L().Info("starting backfill", zap.Uint64("from", from), zap.Uint64("head", head))
+
+	requests := 0
+	forEachBatch("oracle requests", from, head, func(opts *bind.FilterOpts) error {
+		it, err := m.oracle.FilterOracleRequest(opts, nil)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+
+		for it.Next() {
+			requests++
+			if err := m.handleRequest(it.Event); err != nil {
+				zap.L().Warn("failed to handle backfilled request", zap.Error(err))
+			}
+		}
+		return it.Error()
+	})
+
+	dispatchedLogs := 0
+	for _, query := range m.dispatcher.Queries() {
+		forEachBatch("dispatched events", from, head, func(opts *bind.FilterOpts) error {
+			query.FromBlock = new(big.Int).SetUint64(opts.Start)
+			query.ToBlock = nil
+			if opts.End != nil {
+				query.ToBlock = new(big.Int).SetUint64(*opts.End)
+			}
+
+			logs, err := m.client.FilterLogs(opts.Context, query)
+			if err != nil {
+				return err
+			}
+			for _, l := range logs {
+				dispatchedLogs++
+				m.dispatcher.Dispatch(l)
+			}
+			return nil
+		})
+	}
+
+	cancellations := 0
+	forEachBatch("oracle request cancellations", from, head, func(opts *bind.FilterOpts) error {
+		it, err := m.oracle.FilterCancelOracleRequest(opts, nil)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+
+		for it.Next() {
+			cancellations++
+			m.handleCancellation(it.Event)
+		}
+		return it.Error()
+	})
+
+	zap.L().Info("backfill finished", zap.Uint64("from", from), zap.Uint64("head", head),
+		zap.Int("requests", requests), zap.Int("dispatched_logs", dispatchedLogs),
+		zap.Int("cancellations", cancellations))
+}
+
+// forEachBatch calls fn for consecutive block ranges covering [from, head]. The last range is left open-ended
+// so that events mined while the backfill is running are not lost before the live subscriptions take over.
+// A range is retried until fn succeeds for it.
+func forEachBatch(events string, from, head uint64, fn func(opts *bind.FilterOpts) error) {
+	for start := from; ; start += backfillBatchSize {
+		opts := &bind.FilterOpts{Start: start}
+		if end := start + backfillBatchSize - 1; end < head {
+			opts.End = &end
+		}
+
+		retryBackfill(events, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			opts.Context = ctx
+			return fn(opts)
+		})
+		if opts.End == nil {
+			return
+		}
+	}
+}
+
+// retryBackfill calls fn until it succeeds, backing off after every failure.
+func retryBackfill(step string, fn func() error) {
+	delay := backfillRetryDelay
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+
+		zap.L().Error("failed to backfill, retrying", zap.String("step", step), zap.Error(err),
+			zap.Duration("delay", delay))
+		time.Sleep(delay)
+		if delay *= 2; delay > backfillMaxRetryDelay {
+			delay = backfillMaxRetryDelay
+		}
+	}
+}
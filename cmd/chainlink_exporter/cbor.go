@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+const (
+	// cborMaxDepth limits the nesting of decoded arrays, maps and tags
+	cborMaxDepth = 32
+)
+
+var (
+	// errCBORBreak is returned when the break code of an indefinite length item is read
+	errCBORBreak = errors.New("unexpected break code")
+)
+
+type (
+	// cborDecoder decodes the subset of CBOR (RFC 7049) used by Chainlink requests. Integers are decoded as
+	// *big.Int, maps as map[string]interface{} with their keys formatted as strings.
+	cborDecoder struct {
+		data []byte
+		pos  int
+	}
+)
+
+// decodeCBOR decodes a single CBOR data item that spans all of data.
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%d trailing bytes", len(d.data)-d.pos)
+	}
+
+	return v, nil
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("unexpected end of data at offset %d", d.pos)
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+
+	return b, nil
+}
+
+// argument reads the argument of an item with the given additional information. indefinite is set if the item
+// has an indefinite length.
+func (d *cborDecoder) argument(info byte) (arg uint64, indefinite bool, err error) {
+	switch {
+	case info < 24:
+		return uint64(info), false, nil
+	case info <= 27:
+		b, err := d.read(1 << (info - 24))
+		if err != nil {
+			return 0, false, err
+		}
+		for _, x := range b {
+			arg = arg<<8 | uint64(x)
+		}
+		return arg, false, nil
+	case info == 31:
+		return 0, true, nil
+	default:
+		return 0, false, fmt.Errorf("invalid additional information %d at offset %d", info, d.pos-1)
+	}
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("maximum nesting depth exceeded")
+	}
+	head, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	if head[0] == 0xff {
+		return nil, errCBORBreak
+	}
+
+	major, info := head[0]>>5, head[0]&0x1f
+	if major == 7 {
+		return d.simple(info)
+	}
+	arg, indefinite, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+	if indefinite && major < 2 || indefinite && major == 6 {
+		return nil, fmt.Errorf("invalid indefinite length of major type %d", major)
+	}
+
+	switch major {
+	case 0:
+		return new(big.Int).SetUint64(arg), nil
+	case 1:
+		n := new(big.Int).SetUint64(arg)
+		return n.Neg(n).Sub(n, big.NewInt(1)), nil
+	case 2, 3:
+		b, err := d.bytes(major, arg, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return b, nil
+	case 4:
+		var items []interface{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			item, err := d.decode(depth + 1)
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		m := map[string]interface{}{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = value
+		}
+		return m, nil
+	default:
+		// Tags. Bignums are converted, other tags are ignored.
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := value.([]byte); ok && (arg == 2 || arg == 3) {
+			n := new(big.Int).SetBytes(b)
+			if arg == 3 {
+				n.Neg(n).Sub(n, big.NewInt(1))
+			}
+			return n, nil
+		}
+		return value, nil
+	}
+}
+
+// bytes reads the content of a byte or text string. Indefinite length strings are concatenated from their chunks.
+func (d *cborDecoder) bytes(major byte, length uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		return d.read(length)
+	}
+
+	var b []byte
+	for {
+		head, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		if head[0] == 0xff {
+			return b, nil
+		}
+		if head[0]>>5 != major {
+			return nil, fmt.Errorf("invalid chunk of major type %d at offset %d", head[0]>>5, d.pos-1)
+		}
+		n, indefinite, err := d.argument(head[0] & 0x1f)
+		if err != nil {
+			return nil, err
+		}
+		if indefinite {
+			return nil, fmt.Errorf("nested indefinite length string at offset %d", d.pos-1)
+		}
+		chunk, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+}
+
+// simple decodes the simple values and floats of major type 7.
+func (d *cborDecoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	default:
+		return nil, fmt.Errorf("unsupported simple value %d at offset %d", info, d.pos-1)
+	}
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+
+	return v
+}
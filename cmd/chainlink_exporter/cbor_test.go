@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// chainlinkBuffer is the request data Chainlink.sol builds for add("get", "https://example.com/api"),
+// addStringArray("path", ["data", "price"]), addInt("times", 100) and addStringArray("copyPath", ["USD"]).
+const chainlinkBuffer = "636765747768747470733a2f2f6578616d706c652e636f6d2f6170696470617468" +
+	"9f6464617461657072696365ff6574696d6573186468636f7079506174689f63555344ff"
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "small uint", data: "0a", want: "10"},
+		{name: "uint16", data: "190100", want: "256"},
+		{name: "uint64", data: "1bffffffffffffffff", want: "18446744073709551615"},
+		{name: "negative int", data: "3863", want: "-100"},
+		{name: "text", data: "6449455446", want: "IETF"},
+		{name: "bytes", data: "4401020304", want: "[1 2 3 4]"},
+		{name: "array", data: "83010203", want: "[1 2 3]"},
+		{name: "map", data: "a201020304", want: "map[1:2 3:4]"},
+		{name: "indefinite text", data: "7f657374726561646d696e67ff", want: "streaming"},
+		{name: "indefinite bytes", data: "5f42010243030405ff", want: "[1 2 3 4 5]"},
+		{name: "indefinite array", data: "9f018202039f0405ffff", want: "[1 [2 3] [4 5]]"},
+		{name: "indefinite map", data: "bf61610161629f0203ffff", want: "map[a:1 b:[2 3]]"},
+		{name: "bignum", data: "c249010000000000000000", want: "18446744073709551616"},
+		{name: "negative bignum", data: "c349010000000000000000", want: "-18446744073709551617"},
+		{name: "other tag", data: "c11a514b67b0", want: "1363896240"},
+		{name: "half float", data: "f93e00", want: "1.5"},
+		{name: "single float", data: "fa47c35000", want: "100000"},
+		{name: "double float", data: "fb3ff199999999999a", want: "1.1"},
+		{name: "simple values", data: "83f4f5f6", want: "[false true <nil>]"},
+		{name: "nesting at depth limit", data: strings.Repeat("81", cborMaxDepth) + "01",
+			want: strings.Repeat("[", cborMaxDepth) + "1" + strings.Repeat("]", cborMaxDepth)},
+
+		{name: "empty", data: "", wantErr: true},
+		{name: "truncated argument", data: "1901", wantErr: true},
+		{name: "truncated text", data: "644945", wantErr: true},
+		{name: "truncated array", data: "8301", wantErr: true},
+		{name: "truncated map value", data: "a16161", wantErr: true},
+		{name: "unterminated indefinite text", data: "7f6161", wantErr: true},
+		{name: "unterminated indefinite map", data: "bf616101", wantErr: true},
+		{name: "trailing bytes", data: "0a0b", wantErr: true},
+		{name: "nesting beyond depth limit", data: strings.Repeat("81", cborMaxDepth+1) + "01", wantErr: true},
+		{name: "break outside indefinite item", data: "ff", wantErr: true},
+		{name: "break in definite array", data: "82ff", wantErr: true},
+		{name: "break as map value", data: "bf6161ffff", wantErr: true},
+		{name: "indefinite uint", data: "1f", wantErr: true},
+		{name: "indefinite tag", data: "df01", wantErr: true},
+		{name: "nested indefinite text", data: "7f7f6161ffff", wantErr: true},
+		{name: "chunk of other major type", data: "7f4161ff", wantErr: true},
+		{name: "reserved additional information", data: "1c", wantErr: true},
+		{name: "unsupported simple value", data: "f820", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := decodeCBOR(data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprint(v); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int64
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "chainlink buffer", version: requestDataVersion, data: chainlinkBuffer,
+			want: "map[copyPath:[USD] get:https://example.com/api path:[data price] times:100]"},
+		{name: "empty buffer", version: requestDataVersion, data: "", want: "map[]"},
+		{name: "unsupported version", version: 2, data: chainlinkBuffer, wantErr: true},
+		{name: "truncated buffer", version: requestDataVersion, data: chainlinkBuffer[:len(chainlinkBuffer)-4], wantErr: true},
+		{name: "key without value", version: requestDataVersion, data: "63676574", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			params, err := decodeRequestParams(big.NewInt(tt.version), data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprint(params); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
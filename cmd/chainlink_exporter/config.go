@@ -0,0 +1,586 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mainnetLinkAddress = "0x514910771af9ca656af840dff83e8264ecf986ca"
+)
+
+type (
+	// Config is the configuration of the exporter. It is either read from the YAML file referenced by the CONFIG
+	// environment variable or built from the legacy environment variables.
+	Config struct {
+		// ListenAddr is the address the metrics server listens on
+		ListenAddr string `yaml:"listen_addr"`
+		// RPC is the URL of the ethereum node. It needs to be a websocket URL unless PollInterval is set.
+		RPC string `yaml:"rpc"`
+		// RPCEndpoints are additional URLs to fail over to. RPC is used first if it is set.
+		RPCEndpoints []string `yaml:"rpc_endpoints"`
+		// RPCMaxHeadLag is the number of blocks an endpoint may lag behind the best endpoint before it is
+		// considered unhealthy
+		RPCMaxHeadLag *uint64 `yaml:"rpc_max_head_lag"`
+		// PollInterval enables polling the RPC endpoints for new blocks and logs instead of subscribing to them.
+		// This allows to use HTTP endpoints.
+		PollInterval time.Duration `yaml:"poll_interval"`
+		// LinkAddress is the address of the LINK token contract
+		LinkAddress string `yaml:"link_address"`
+		// LinkEthPrice is a static price of one LINK in ETH used to calculate profits
+		LinkEthPrice float64 `yaml:"link_eth_price"`
+		// LinkEthFeed is the address of a LINK/ETH price feed aggregator. It takes precedence over LinkEthPrice.
+		LinkEthFeed string `yaml:"link_eth_feed"`
+		// LinkEthFeedDecimals is the number of decimals of the price feed answer
+		LinkEthFeedDecimals *uint8 `yaml:"link_eth_feed_decimals"`
+		// RequestParamLabels are the request parameters that are added as labels to the per-request metrics. The
+		// pseudo parameter host is the host of the requested URL.
+		RequestParamLabels []string `yaml:"request_param_labels"`
+		// Metrics configures the names, labels and buckets of the metrics
+		Metrics MetricsConfig `yaml:"metrics"`
+		// ResponseTimeSecondsBuckets are the buckets of the response time in seconds by spec ID. All other specs use
+		// the buckets of response_time_seconds in Metrics.
+		ResponseTimeSecondsBuckets map[string][]float64 `yaml:"response_time_seconds_buckets"`
+		// Jobs describe the jobs of the nodes by spec ID
+		Jobs map[string]JobConfig `yaml:"jobs"`
+		// JobAPI is the API of a Chainlink node the job names are pulled from
+		JobAPI *JobAPIConfig `yaml:"job_api"`
+		// StateDir is the directory the state stores of all oracles are kept in, unless an oracle sets its own path
+		StateDir string `yaml:"state_dir"`
+
+		Oracles []OracleConfig `yaml:"oracles"`
+	}
+
+	OracleConfig struct {
+		// Address is the address of the oracle contract
+		Address string `yaml:"address"`
+		// Nodes are the addresses of the node keys that fulfill requests to the oracle
+		Nodes []string `yaml:"nodes"`
+		// Labels are added to all metrics of the oracle. All oracles need to use the same label names.
+		Labels map[string]string `yaml:"labels"`
+
+		BackfillBlocks     uint64            `yaml:"backfill_blocks"`
+		StorePath          string            `yaml:"store_path"`
+		Confirmations      *uint64           `yaml:"confirmations"`
+		DeadlineBlocks     map[string]uint64 `yaml:"deadline_blocks"`
+		CancelWindowBlocks uint64            `yaml:"cancel_window_blocks"`
+	}
+
+	MetricsConfig struct {
+		// Namespace and Subsystem form the prefix of all metric names. They default to cl and mon.
+		Namespace *string `yaml:"namespace"`
+		Subsystem *string `yaml:"subsystem"`
+		// Labels are added to all metrics, e.g. the environment, network or node name
+		Labels map[string]string `yaml:"labels"`
+		// Buckets are the histogram buckets by metric name without prefix
+		Buckets map[string][]float64 `yaml:"buckets"`
+	}
+
+	JobConfig struct {
+		Name string   `yaml:"name"`
+		Tags []string `yaml:"tags"`
+		// ResponseWindow is the number of blocks requests of the job are expected to be fulfilled in
+		ResponseWindow uint64 `yaml:"response_window"`
+	}
+
+	JobAPIConfig struct {
+		URL      string `yaml:"url"`
+		Email    string `yaml:"email"`
+		Password string `yaml:"password"`
+	}
+)
+
+// LoadConfig reads the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	return cfg, cfg.validate()
+}
+
+// ConfigFromEnv builds a configuration for a single oracle from the environment.
+func ConfigFromEnv() (*Config, error) {
+	cfg := &Config{
+		ListenAddr:  os.Getenv("LADDR"),
+		LinkAddress: os.Getenv("LINK_ADDRESS"),
+		LinkEthFeed: os.Getenv("LINK_ETH_FEED"),
+	}
+	if rpc := os.Getenv("RPC"); rpc != "" {
+		cfg.RPCEndpoints = splitList(rpc)
+	}
+	if maxHeadLag := os.Getenv("RPC_MAX_HEAD_LAG"); maxHeadLag != "" {
+		n, err := strconv.ParseUint(maxHeadLag, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RPC_MAX_HEAD_LAG: %w", err)
+		}
+		cfg.RPCMaxHeadLag = &n
+	}
+	if price := os.Getenv("LINK_ETH_PRICE"); price != "" {
+		p, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LINK_ETH_PRICE: %w", err)
+		}
+		cfg.LinkEthPrice = p
+	}
+	if decimals := os.Getenv("LINK_ETH_FEED_DECIMALS"); decimals != "" {
+		n, err := strconv.ParseUint(decimals, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LINK_ETH_FEED_DECIMALS: %w", err)
+		}
+		d := uint8(n)
+		cfg.LinkEthFeedDecimals = &d
+	}
+	if jobNames := os.Getenv("JOB_NAMES"); jobNames != "" {
+		names, err := parseJobNames(jobNames)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JOB_NAMES: %w", err)
+		}
+		cfg.Jobs = map[string]JobConfig{}
+		for specID, name := range names {
+			cfg.Jobs[specID] = JobConfig{Name: name}
+		}
+	}
+	if jobAPI := os.Getenv("JOB_API_URL"); jobAPI != "" {
+		cfg.JobAPI = &JobAPIConfig{
+			URL:      jobAPI,
+			Email:    os.Getenv("JOB_API_EMAIL"),
+			Password: os.Getenv("JOB_API_PASSWORD"),
+		}
+	}
+	if namespace, ok := os.LookupEnv("METRICS_NAMESPACE"); ok {
+		cfg.Metrics.Namespace = &namespace
+	}
+	if subsystem, ok := os.LookupEnv("METRICS_SUBSYSTEM"); ok {
+		cfg.Metrics.Subsystem = &subsystem
+	}
+	if labels := os.Getenv("METRICS_LABELS"); labels != "" {
+		l, err := parseLabels(labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid METRICS_LABELS: %w", err)
+		}
+		cfg.Metrics.Labels = l
+	}
+	if buckets := os.Getenv("METRICS_BUCKETS"); buckets != "" {
+		b, err := parseBuckets(buckets)
+		if err != nil {
+			return nil, fmt.Errorf("invalid METRICS_BUCKETS: %w", err)
+		}
+		cfg.Metrics.Buckets = b
+	}
+	if buckets := os.Getenv("RESPONSE_TIME_SECONDS_BUCKETS"); buckets != "" {
+		b, err := parseBuckets(buckets)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RESPONSE_TIME_SECONDS_BUCKETS: %w", err)
+		}
+		cfg.ResponseTimeSecondsBuckets = b
+	}
+	if paramLabels := os.Getenv("REQUEST_PARAM_LABELS"); paramLabels != "" {
+		cfg.RequestParamLabels = splitList(paramLabels)
+	}
+	if pollInterval := os.Getenv("POLL_INTERVAL"); pollInterval != "" {
+		d, err := time.ParseDuration(pollInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POLL_INTERVAL: %w", err)
+		}
+		cfg.PollInterval = d
+	}
+
+	oracle := OracleConfig{
+		Address:   os.Getenv("ADDRESS"),
+		StorePath: os.Getenv("STORE_PATH"),
+	}
+	if nodes := os.Getenv("NODE_ADDRESS"); nodes != "" {
+		oracle.Nodes = splitList(nodes)
+	}
+	if backfillBlocks := os.Getenv("BACKFILL_BLOCKS"); backfillBlocks != "" {
+		n, err := strconv.ParseUint(backfillBlocks, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BACKFILL_BLOCKS: %w", err)
+		}
+		oracle.BackfillBlocks = n
+	}
+	if confirmations := os.Getenv("CONFIRMATIONS"); confirmations != "" {
+		n, err := strconv.ParseUint(confirmations, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFIRMATIONS: %w", err)
+		}
+		oracle.Confirmations = &n
+	}
+	if deadlineBlocks := os.Getenv("DEADLINE_BLOCKS"); deadlineBlocks != "" {
+		deadlines, err := parseDeadlineBlocks(deadlineBlocks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEADLINE_BLOCKS: %w", err)
+		}
+		oracle.DeadlineBlocks = deadlines
+	}
+	if cancelWindow := os.Getenv("CANCEL_WINDOW_BLOCKS"); cancelWindow != "" {
+		n, err := strconv.ParseUint(cancelWindow, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CANCEL_WINDOW_BLOCKS: %w", err)
+		}
+		oracle.CancelWindowBlocks = n
+	}
+	cfg.Oracles = []OracleConfig{oracle}
+
+	return cfg, cfg.validate()
+}
+
+func (c *Config) validate() error {
+	if c.ListenAddr == "" {
+		return fmt.Errorf("LADDR must be set")
+	}
+	if len(c.Endpoints()) == 0 {
+		return fmt.Errorf("RPC must be set")
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("POLL_INTERVAL must not be negative")
+	}
+	if c.LinkAddress == "" {
+		zap.L().Warn("LINK_ADDRESS isn't set. Falling back to mainnet default.")
+		c.LinkAddress = mainnetLinkAddress
+	} else if !common.IsHexAddress(c.LinkAddress) {
+		return fmt.Errorf("invalid LINK address %q", c.LinkAddress)
+	}
+	if c.LinkEthFeed != "" && !common.IsHexAddress(c.LinkEthFeed) {
+		return fmt.Errorf("invalid LINK/ETH feed address %q", c.LinkEthFeed)
+	}
+	if c.LinkEthPrice < 0 {
+		return fmt.Errorf("LINK_ETH_PRICE must not be negative")
+	}
+	paramKeys := map[string]bool{}
+	for _, key := range c.RequestParamLabels {
+		if !validParamKey(key) {
+			return fmt.Errorf("invalid request parameter label %q", key)
+		}
+		if paramKeys[key] {
+			return fmt.Errorf("request parameter label %q is configured more than once", key)
+		}
+		paramKeys[key] = true
+	}
+	if err := c.Metrics.validate(); err != nil {
+		return err
+	}
+	reservedLabels := map[string]bool{"oracle": true}
+	for _, name := range append(paramLabelNames(c.RequestParamLabels), variableLabels...) {
+		reservedLabels[name] = true
+	}
+	for name := range c.Metrics.Labels {
+		if reservedLabels[name] {
+			return fmt.Errorf("metrics label %s is reserved", name)
+		}
+	}
+	for specID, buckets := range c.ResponseTimeSecondsBuckets {
+		if specID == "default" {
+			return fmt.Errorf("invalid response time spec default: the default buckets are the metrics buckets of response_time_seconds")
+		}
+		if err := validateBuckets(buckets); err != nil {
+			return fmt.Errorf("invalid response time buckets of %s: %w", specID, err)
+		}
+	}
+	if c.JobAPI != nil && (c.JobAPI.URL == "" || c.JobAPI.Email == "" || c.JobAPI.Password == "") {
+		return fmt.Errorf("the job API needs a URL, email and password")
+	}
+	if len(c.Oracles) == 0 {
+		return fmt.Errorf("no oracles configured")
+	}
+
+	var labelNames []string
+	addresses := map[common.Address]bool{}
+	for i, oracle := range c.Oracles {
+		if oracle.Address == "" {
+			return fmt.Errorf("oracle %d: ADDRESS must be set", i)
+		}
+		if !common.IsHexAddress(oracle.Address) {
+			return fmt.Errorf("oracle %d: invalid address %q", i, oracle.Address)
+		}
+		if addresses[common.HexToAddress(oracle.Address)] {
+			return fmt.Errorf("oracle %s is configured more than once", oracle.Address)
+		}
+		addresses[common.HexToAddress(oracle.Address)] = true
+		if len(oracle.Nodes) == 0 {
+			return fmt.Errorf("oracle %s: NODE_ADDRESS must be set", oracle.Address)
+		}
+		for _, node := range oracle.Nodes {
+			if !common.IsHexAddress(node) {
+				return fmt.Errorf("oracle %s: invalid node address %q", oracle.Address, node)
+			}
+		}
+		for name := range oracle.Labels {
+			if !validName(name) || strings.HasPrefix(name, "__") {
+				return fmt.Errorf("oracle %s: invalid label %q", oracle.Address, name)
+			}
+			if reservedLabels[name] {
+				return fmt.Errorf("oracle %s: label %s is reserved", oracle.Address, name)
+			}
+			if _, ok := c.Metrics.Labels[name]; ok {
+				return fmt.Errorf("oracle %s: label %s is already set for all metrics", oracle.Address, name)
+			}
+		}
+
+		// Metrics with the same name need to have the same label names
+		names := sortedKeys(oracle.Labels)
+		if i == 0 {
+			labelNames = names
+		} else if strings.Join(names, ",") != strings.Join(labelNames, ",") {
+			return fmt.Errorf("oracle %s: all oracles need to use the same label names", oracle.Address)
+		}
+	}
+
+	return nil
+}
+
+// Endpoints returns all configured RPC endpoints in the order they should be used.
+func (c *Config) Endpoints() []string {
+	if c.RPC == "" {
+		return c.RPCEndpoints
+	}
+
+	return append([]string{c.RPC}, c.RPCEndpoints...)
+}
+
+// MaxHeadLag returns the number of blocks an RPC endpoint may lag behind.
+func (c *Config) MaxHeadLag() uint64 {
+	if c.RPCMaxHeadLag == nil {
+		return DefaultMaxHeadLag
+	}
+
+	return *c.RPCMaxHeadLag
+}
+
+// JobRegistry creates the registry of the configured jobs. Its metrics are registered with registerer.
+func (c *Config) JobRegistry(registerer prometheus.Registerer) (*JobRegistry, error) {
+	jobs := map[string]JobInfo{}
+	for specID, job := range c.Jobs {
+		jobs[specID] = JobInfo{Name: job.Name, Tags: job.Tags, ResponseWindow: job.ResponseWindow}
+	}
+
+	var api *JobAPI
+	if c.JobAPI != nil {
+		var err error
+		api, err = NewJobAPI(c.JobAPI.URL, c.JobAPI.Email, c.JobAPI.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewJobRegistry(jobs, api, registerer), nil
+}
+
+// MetricPrefix returns the prefix of all metric names.
+func (c *Config) MetricPrefix() string {
+	namespace, subsystem := DefaultMetricsNamespace, DefaultMetricsSubsystem
+	if c.Metrics.Namespace != nil {
+		namespace = *c.Metrics.Namespace
+	}
+	if c.Metrics.Subsystem != nil {
+		subsystem = *c.Metrics.Subsystem
+	}
+
+	return MetricPrefix(namespace, subsystem)
+}
+
+// Registerer wraps registry so that the registered metrics get the configured prefix and labels. It is used for
+// the metrics that don't belong to a monitor.
+func (c *Config) Registerer(registry *prometheus.Registry) prometheus.Registerer {
+	return prometheus.WrapRegistererWith(c.Metrics.Labels, prometheus.WrapRegistererWithPrefix(c.MetricPrefix(), registry))
+}
+
+// MonitorConfig returns the monitor configuration of the i-th oracle.
+func (c *Config) MonitorConfig(i int) MonitorConfig {
+	oracle := c.Oracles[i]
+
+	cfg := MonitorConfig{
+		Address:        common.HexToAddress(oracle.Address),
+		LinkAddress:    common.HexToAddress(c.LinkAddress),
+		Labels:         map[string]string{},
+		BackfillBlocks: oracle.BackfillBlocks,
+		StorePath:      oracle.StorePath,
+		Confirmations:  DefaultConfirmations,
+		DeadlineBlocks: oracle.DeadlineBlocks,
+		PollInterval:   c.PollInterval,
+		ParamLabels:    c.RequestParamLabels,
+
+		CancelWindowBlocks: oracle.CancelWindowBlocks,
+
+		MetricPrefix:                   c.MetricPrefix(),
+		Buckets:                        map[string][]float64{},
+		SpecResponseTimeSecondsBuckets: map[string][]float64{},
+
+		LinkEthPrice:        c.LinkEthPrice,
+		LinkEthFeedDecimals: DefaultPriceFeedDecimals,
+	}
+	if c.LinkEthFeed != "" {
+		feed := common.HexToAddress(c.LinkEthFeed)
+		cfg.LinkEthFeed = &feed
+	}
+	if c.LinkEthFeedDecimals != nil {
+		cfg.LinkEthFeedDecimals = *c.LinkEthFeedDecimals
+	}
+	for name, value := range c.Metrics.Labels {
+		cfg.Labels[name] = value
+	}
+	for name, value := range oracle.Labels {
+		cfg.Labels[name] = value
+	}
+	for name, buckets := range c.Metrics.Buckets {
+		cfg.Buckets[name] = buckets
+	}
+	for specID, buckets := range c.ResponseTimeSecondsBuckets {
+		cfg.SpecResponseTimeSecondsBuckets[specID] = buckets
+	}
+	for _, node := range oracle.Nodes {
+		cfg.Nodes = append(cfg.Nodes, common.HexToAddress(node))
+	}
+	if oracle.Confirmations != nil {
+		cfg.Confirmations = *oracle.Confirmations
+	}
+	if cfg.StorePath == "" && c.StateDir != "" {
+		cfg.StorePath = filepath.Join(c.StateDir, cfg.Address.String())
+	}
+
+	return cfg
+}
+
+// parseDeadlineBlocks parses a comma separated list of spec_id=blocks pairs.
+func parseDeadlineBlocks(s string) (map[string]uint64, error) {
+	deadlines := map[string]uint64{}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+
+		n, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q: %w", entry, err)
+		}
+		deadlines[strings.TrimSpace(parts[0])] = n
+	}
+
+	return deadlines, nil
+}
+
+// parseJobNames parses a comma separated list of spec_id=name pairs.
+func parseJobNames(s string) (map[string]string, error) {
+	names := map[string]string{}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+		names[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return names, nil
+}
+
+// parseLabels parses a comma separated list of name=value pairs.
+func parseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return labels, nil
+}
+
+// parseBuckets parses a comma separated list of key=buckets pairs. The buckets are separated by spaces.
+func parseBuckets(s string) (map[string][]float64, error) {
+	buckets := map[string][]float64{}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+
+		var b []float64
+		for _, field := range strings.Fields(parts[1]) {
+			f, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry %q: %w", entry, err)
+			}
+			b = append(b, f)
+		}
+		buckets[strings.TrimSpace(parts[0])] = b
+	}
+
+	return buckets, nil
+}
+
+// validateBuckets checks that histogram buckets are set and increasing.
+func validateBuckets(buckets []float64) error {
+	if len(buckets) == 0 {
+		return fmt.Errorf("no buckets")
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("buckets must be in increasing order")
+		}
+	}
+
+	return nil
+}
+
+func (c *MetricsConfig) validate() error {
+	for _, part := range []*string{c.Namespace, c.Subsystem} {
+		if part != nil && *part != "" && !validName(*part) {
+			return fmt.Errorf("invalid metrics namespace or subsystem %q", *part)
+		}
+	}
+	for name := range c.Labels {
+		if !validName(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid metrics label %q", name)
+		}
+	}
+	for name, buckets := range c.Buckets {
+		if _, ok := DefaultBuckets[name]; !ok {
+			return fmt.Errorf("unknown histogram %q", name)
+		}
+		if err := validateBuckets(buckets); err != nil {
+			return fmt.Errorf("invalid buckets of %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list and trims the spaces around its entries.
+func splitList(s string) []string {
+	entries := strings.Split(s, ",")
+	for i, entry := range entries {
+		entries[i] = strings.TrimSpace(entry)
+	}
+
+	return entries
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
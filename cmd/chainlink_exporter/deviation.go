@@ -0,0 +1,87 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+	"math/big"
+)
+
+const (
+	// maxResponseRounds is the number of rounds a response waits for the answer of its round before it is dropped
+	maxResponseRounds = 10
+)
+
+var (
+	responseReceivedTopic = mustEventID(abi.AggregatorABI, "ResponseReceived")
+)
+
+// handleResponseReceivedLog decodes a ResponseReceived log of the aggregator passed in by the LogDispatcher. It ranks
+// the responses of our oracle among all responses of the round and keeps them until the answer of their round is known.
+func (a *AggregatorMonitor) handleResponseReceivedLog(l types.Log) error {
+	response, err := a.aggregator.ParseResponseReceived(l)
+	if err != nil {
+		return err
+	}
+	if response.Raw.Removed {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	rank := a.recordResponder(response.AnswerId.Uint64(), responder{
+		sender: response.Sender,
+		tx:     response.Raw.TxHash,
+		index:  response.Raw.Index,
+	})
+	if response.Sender != a.monitor.addr {
+		return nil
+	}
+	a.rankResponse(response.AnswerId, rank)
+
+	if a.correlatedRound != nil && response.AnswerId.Cmp(a.correlatedRound) <= 0 {
+		// Already compared, e.g. replayed by the backfill
+		return nil
+	}
+	a.responses[response.AnswerId.Uint64()] = response.Response
+
+	return nil
+}
+
+// correlateResponse compares the response of our oracle to the answer of its round. Must be called with the lock held.
+func (a *AggregatorMonitor) correlateResponse(update *abi.AggregatorAnswerUpdated) {
+	round := update.RoundId.Uint64()
+	for r := range a.responses {
+		if r+maxResponseRounds < round {
+			delete(a.responses, r)
+		}
+	}
+
+	response, ok := a.responses[round]
+	if !ok {
+		return
+	}
+	delete(a.responses, round)
+	if a.correlatedRound == nil || update.RoundId.Cmp(a.correlatedRound) > 0 {
+		a.correlatedRound = update.RoundId
+	}
+
+	deviation := new(big.Int).Sub(response, update.Current)
+	deviation.Abs(deviation)
+	abs, _ := new(big.Float).SetInt(deviation).Float64()
+	a.monitor.deviationHistogram.WithLabelValues(a.address.String()).Observe(abs)
+
+	if update.Current.Sign() == 0 {
+		return
+	}
+	bps, _ := new(big.Float).Quo(
+		new(big.Float).SetInt(new(big.Int).Mul(deviation, big.NewInt(10000))),
+		new(big.Float).SetInt(new(big.Int).Abs(update.Current)),
+	).Float64()
+	a.monitor.deviationBpsHistogram.WithLabelValues(a.address.String()).Observe(bps)
+
+	zap.L().Debug("response compared to answer", zap.String("address", a.address.String()),
+		zap.Uint64("round", round), zap.String("response", response.String()),
+		zap.String("answer", update.Current.String()), zap.Float64("deviation_bps", bps))
+}
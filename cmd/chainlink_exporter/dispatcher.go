@@ -0,0 +1,282 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"context"
+	"github.com/ethereum/go-ethereum"
+	ethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	oracleRequestTopic       = mustEventID(abi.OracleABI, "OracleRequest")
+	cancelOracleRequestTopic = mustEventID(abi.OracleABI, "CancelOracleRequest")
+	chainlinkFulfilledTopic  = mustEventID(abi.AggregatorABI, "ChainlinkFulfilled")
+)
+
+type (
+	// LogHandler decodes and handles a log of a registered contract event.
+	LogHandler func(l types.Log) error
+
+	// LogDispatcher watches the events of all registered contracts with a single log subscription and routes
+	// the logs to the handler registered for their address and event. Filters that need indexed arguments get a
+	// subscription of their own.
+	LogDispatcher struct {
+		client   EthClient
+		handlers map[common.Address]map[common.Hash]LogHandler
+		filters  []logFilter
+
+		// changed signals the routine to resubscribe with the updated query
+		changed chan struct{}
+		lock    sync.Mutex
+	}
+
+	// logKey identifies a log
+	logKey struct {
+		tx    common.Hash
+		index uint
+	}
+
+	// logFilter routes the logs matching query to handler
+	logFilter struct {
+		query   ethereum.FilterQuery
+		handler LogHandler
+	}
+)
+
+func NewLogDispatcher(client EthClient) *LogDispatcher {
+	return &LogDispatcher{
+		client:   client,
+		handlers: map[common.Address]map[common.Hash]LogHandler{},
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// Register routes the logs of the given event of a contract to handler.
+func (d *LogDispatcher) Register(address common.Address, topic common.Hash, handler LogHandler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.handlers[address] == nil {
+		d.handlers[address] = map[common.Hash]LogHandler{}
+	}
+	d.handlers[address][topic] = handler
+
+	select {
+	case d.changed <- struct{}{}:
+	default:
+		// A resubscription is already pending
+	}
+}
+
+// RegisterFilter routes the logs matching query to handler. Unlike Register, the query can restrict the indexed
+// arguments of an event, e.g. to the transfers of a single account of a token.
+func (d *LogDispatcher) RegisterFilter(query ethereum.FilterQuery, handler LogHandler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.filters = append(d.filters, logFilter{query: query, handler: handler})
+
+	select {
+	case d.changed <- struct{}{}:
+	default:
+		// A resubscription is already pending
+	}
+}
+
+// Queries returns the log queries covering all registered handlers and filters.
+func (d *LogDispatcher) Queries() []ethereum.FilterQuery {
+	var queries []ethereum.FilterQuery
+	if addresses, topics := d.Query(); len(addresses) > 0 {
+		queries = append(queries, ethereum.FilterQuery{Addresses: addresses, Topics: [][]common.Hash{topics}})
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, filter := range d.filters {
+		queries = append(queries, filter.query)
+	}
+
+	return queries
+}
+
+// Query returns the addresses and event topics of all registered handlers.
+func (d *LogDispatcher) Query() ([]common.Address, []common.Hash) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	addresses := make([]common.Address, 0, len(d.handlers))
+	topicSet := map[common.Hash]bool{}
+	for address, handlers := range d.handlers {
+		addresses = append(addresses, address)
+		for topic := range handlers {
+			topicSet[topic] = true
+		}
+	}
+
+	topics := make([]common.Hash, 0, len(topicSet))
+	for topic := range topicSet {
+		topics = append(topics, topic)
+	}
+
+	return addresses, topics
+}
+
+// Dispatch passes a log to the handler of its contract and event or of the first filter it matches. Logs without a
+// handler are ignored.
+func (d *LogDispatcher) Dispatch(l types.Log) {
+	if len(l.Topics) == 0 {
+		return
+	}
+
+	d.lock.Lock()
+	handler, ok := d.handlers[l.Address][l.Topics[0]]
+	for i := 0; !ok && i < len(d.filters); i++ {
+		if matchesQuery(d.filters[i].query, l) {
+			handler, ok = d.filters[i].handler, true
+		}
+	}
+	d.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := handler(l); err != nil {
+		zap.L().Warn("failed to handle log", zap.Error(err), zap.String("address", l.Address.String()),
+			zap.String("tx", l.TxHash.String()))
+	}
+}
+
+// Run subscribes to the logs of all registered handlers and filters and dispatches them. When a handler or filter
+// is registered, the new subscriptions are opened before the old ones are closed. Logs delivered by both are
+// deduplicated by the handlers.
+func (d *LogDispatcher) Run() {
+	for {
+		zap.L().Info("Starting log dispatcher routine")
+		func() {
+			logChan := make(chan types.Log, 100)
+			errChan := make(chan error, 1)
+
+			var subs []ethereum.Subscription
+			defer func() {
+				unsubscribeAll(subs)
+			}()
+
+			for {
+				next, err := d.subscribe(logChan, errChan)
+				if err != nil {
+					zap.L().Error("failed to subscribe to logs", zap.Error(err))
+					return
+				}
+				unsubscribeAll(subs)
+				subs = next
+
+			dispatch:
+				for {
+					select {
+					case err := <-errChan:
+						zap.L().Error("log subscription errored", zap.Error(err))
+						return
+					case l := <-logChan:
+						d.Dispatch(l)
+					case <-d.changed:
+						break dispatch
+					}
+				}
+			}
+		}()
+
+		zap.L().Warn("log dispatcher routine died. restarting in 5sec")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// subscribe opens a subscription for every query. The first error of any of them is sent to errChan.
+func (d *LogDispatcher) subscribe(logChan chan<- types.Log, errChan chan<- error) ([]ethereum.Subscription, error) {
+	var subs []ethereum.Subscription
+	for _, query := range d.Queries() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		sub, err := d.client.SubscribeFilterLogs(ctx, query, logChan)
+		cancel()
+		if err != nil {
+			unsubscribeAll(subs)
+			return nil, err
+		}
+		subs = append(subs, sub)
+
+		go func() {
+			// The channel is closed without an error when the subscription is unsubscribed
+			if err, ok := <-sub.Err(); ok {
+				select {
+				case errChan <- err:
+				default:
+				}
+			}
+		}()
+	}
+	zap.L().Debug("subscribed to logs", zap.Int("subscriptions", len(subs)))
+
+	return subs, nil
+}
+
+func unsubscribeAll(subs []ethereum.Subscription) {
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+// matchesQuery reports whether a log matches the addresses and topics of a query.
+func matchesQuery(query ethereum.FilterQuery, l types.Log) bool {
+	if len(query.Addresses) > 0 && !containsAddress(query.Addresses, l.Address) {
+		return false
+	}
+	for i, topics := range query.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		if i >= len(l.Topics) || !containsHash(topics, l.Topics[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsAddress(addresses []common.Address, address common.Address) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsHash(hashes []common.Hash, hash common.Hash) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustEventID(contractABI string, name string) common.Hash {
+	parsed, err := ethabi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		panic(err)
+	}
+	event, ok := parsed.Events[name]
+	if !ok {
+		panic("unknown event " + name)
+	}
+
+	return event.ID()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"testing"
+)
+
+func TestMatchesQuery(t *testing.T) {
+	a, b := common.HexToAddress("0xa"), common.HexToAddress("0xb")
+	t1, t2, t3 := common.HexToHash("0x1"), common.HexToHash("0x2"), common.HexToHash("0x3")
+
+	tests := []struct {
+		name  string
+		query ethereum.FilterQuery
+		log   types.Log
+		want  bool
+	}{
+		{name: "empty query", log: types.Log{Address: a, Topics: []common.Hash{t1}}, want: true},
+		{name: "address", query: ethereum.FilterQuery{Addresses: []common.Address{a}},
+			log: types.Log{Address: a}, want: true},
+		{name: "one of the addresses", query: ethereum.FilterQuery{Addresses: []common.Address{a, b}},
+			log: types.Log{Address: b}, want: true},
+		{name: "other address", query: ethereum.FilterQuery{Addresses: []common.Address{a}},
+			log: types.Log{Address: b}, want: false},
+		{name: "topic", query: ethereum.FilterQuery{Topics: [][]common.Hash{{t1}}},
+			log: types.Log{Topics: []common.Hash{t1, t2}}, want: true},
+		{name: "one of the topics", query: ethereum.FilterQuery{Topics: [][]common.Hash{{t1, t2}}},
+			log: types.Log{Topics: []common.Hash{t2}}, want: true},
+		{name: "other topic", query: ethereum.FilterQuery{Topics: [][]common.Hash{{t1}}},
+			log: types.Log{Topics: []common.Hash{t2}}, want: false},
+		{name: "wildcard position", query: ethereum.FilterQuery{Topics: [][]common.Hash{{}, {t2}}},
+			log: types.Log{Topics: []common.Hash{t3, t2}}, want: true},
+		{name: "other topic in second position", query: ethereum.FilterQuery{Topics: [][]common.Hash{{t1}, {t2}}},
+			log: types.Log{Topics: []common.Hash{t1, t3}}, want: false},
+		{name: "fewer topics than query", query: ethereum.FilterQuery{Topics: [][]common.Hash{{t1}, {t2}}},
+			log: types.Log{Topics: []common.Hash{t1}}, want: false},
+		{name: "trailing wildcard", query: ethereum.FilterQuery{Topics: [][]common.Hash{{t1}, {}}},
+			log: types.Log{Topics: []common.Hash{t1}}, want: true},
+		{name: "address and topic", query: ethereum.FilterQuery{Addresses: []common.Address{a},
+			Topics: [][]common.Hash{{t1}}}, log: types.Log{Address: b, Topics: []common.Hash{t1}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesQuery(tt.query, tt.log); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+	"go.uber.org/zap"
+	"math/big"
+	"time"
+)
+
+const (
+	// costQueueSize is the number of fulfillments whose cost can wait to be fetched
+	costQueueSize = 1000
+	// costMaxAttempts is the number of times the cost of a fulfillment is fetched before it is given up
+	costMaxAttempts = 5
+	// costRetryDelay is the delay before the cost of a fulfillment is fetched again, multiplied by the attempt
+	costRetryDelay = 10 * time.Second
+)
+
+type (
+	// costJob is a fulfillment whose cost is yet to be accounted.
+	costJob struct {
+		res      *abi.AggregatorChainlinkFulfilled
+		req      *abi.OracleOracleRequest
+		attempts int
+	}
+
+	// rpcReceipt is a transaction receipt as returned by eth_getTransactionReceipt. effectiveGasPrice is only
+	// returned by nodes that support dynamic fee transactions.
+	rpcReceipt struct {
+		From              common.Address `json:"from"`
+		GasUsed           hexutil.Uint64 `json:"gasUsed"`
+		EffectiveGasPrice *hexutil.Big   `json:"effectiveGasPrice"`
+	}
+
+	// rpcGasPrice is the gas price of a transaction as returned by eth_getTransactionByHash
+	rpcGasPrice struct {
+		GasPrice *hexutil.Big `json:"gasPrice"`
+	}
+)
+
+// queueCost queues a fulfillment to account its cost. It blocks while the queue is full, e.g. when the
+// fulfillments replayed by the backfill are confirmed at the first head.
+func (m *Monitor) queueCost(job costJob) {
+	m.costs <- job
+}
+
+// costRoutine accounts the costs of the queued fulfillments. Failed fetches are retried after a delay.
+func (m *Monitor) costRoutine() {
+	zap.L().Info("Starting cost routine")
+	for job := range m.costs {
+		gasUsed, cost, sender, err := m.fulfillmentCost(job.res.Raw.TxHash)
+		if err != nil {
+			job.attempts++
+			if job.attempts >= costMaxAttempts {
+				zap.L().Error("failed to fetch fulfillment cost", zap.Error(err),
+					zap.String("tx", job.res.Raw.TxHash.String()), zap.Int("attempts", job.attempts))
+				continue
+			}
+			zap.L().Warn("failed to fetch fulfillment cost, retrying", zap.Error(err),
+				zap.String("tx", job.res.Raw.TxHash.String()))
+			retry := job
+			time.AfterFunc(costRetryDelay*time.Duration(job.attempts), func() { m.queueCost(retry) })
+			continue
+		}
+		m.recordCost(job.req, gasUsed, cost, sender)
+	}
+}
+
+// recordCost exports the gas used and ETH spent by a fulfillment and the resulting profit.
+func (m *Monitor) recordCost(req *abi.OracleOracleRequest, gasUsed uint64, cost *big.Int, sender common.Address) {
+	sanitizedSpecID := sanitizeSpecID(req.SpecId)
+
+	m.gasUsedHistogram.WithLabelValues(sanitizedSpecID, req.Requester.String()).Observe(float64(gasUsed))
+	costEth := weiToEther(cost)
+	m.gasSpentCounter.WithLabelValues(sanitizedSpecID, req.Requester.String()).Add(costEth)
+	if estimator, ok := m.spend[sender]; ok {
+		estimator.ObserveFulfillment(costEth, time.Now())
+	}
+
+	if m.price == nil {
+		return
+	}
+	price, err := m.price.LinkEthPrice()
+	if err != nil {
+		zap.L().Warn("failed to fetch LINK/ETH price, skipping profit", zap.Error(err),
+			zap.String("spec_id", sanitizedSpecID), zap.String("requester", req.Requester.String()))
+		m.profitSkippedCounter.WithLabelValues(sanitizedSpecID, req.Requester.String()).Inc()
+		return
+	}
+	revenueLink := weiToEther(req.Payment)
+	m.profitLinkGauge.WithLabelValues(sanitizedSpecID, req.Requester.String()).Add(revenueLink - costEth/price)
+	m.profitEthGauge.WithLabelValues(sanitizedSpecID, req.Requester.String()).Add(revenueLink*price - costEth)
+}
+
+// fulfillmentCost returns the gas used by a fulfillment transaction, the ETH paid for it in wei and its sender.
+// The cost is based on the effective gas price of the receipt. Nodes that don't return it predate dynamic fee
+// transactions, so the gas price of the transaction is what it paid.
+func (m *Monitor) fulfillmentCost(txHash common.Hash) (uint64, *big.Int, common.Address, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	var receipt *rpcReceipt
+	if err := m.client.CallContext(ctx, &receipt, "eth_getTransactionReceipt", txHash); err != nil {
+		return 0, nil, common.Address{}, err
+	}
+	if receipt == nil {
+		return 0, nil, common.Address{}, ethereum.NotFound
+	}
+
+	gasPrice := (*big.Int)(receipt.EffectiveGasPrice)
+	if gasPrice == nil {
+		var tx *rpcGasPrice
+		if err := m.client.CallContext(ctx, &tx, "eth_getTransactionByHash", txHash); err != nil {
+			return 0, nil, common.Address{}, err
+		}
+		if tx == nil || tx.GasPrice == nil {
+			return 0, nil, common.Address{}, fmt.Errorf("missing gas price of transaction %s", txHash.String())
+		}
+		gasPrice = (*big.Int)(tx.GasPrice)
+	}
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(uint64(receipt.GasUsed)), gasPrice)
+
+	return uint64(receipt.GasUsed), cost, receipt.From, nil
+}
+
+// weiToEther converts an amount in wei, or the smallest unit of LINK, to ether.
+func weiToEther(wei *big.Int) float64 {
+	ether, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.Ether)).Float64()
+	return ether
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jobRefreshInterval is the interval the jobs are pulled from the node API in
+	jobRefreshInterval = 5 * time.Minute
+	// jobPageSize is the number of jobs requested from the node API at once
+	jobPageSize = 1000
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+type (
+	// JobInfo describes the job of a spec ID.
+	JobInfo struct {
+		Name string
+		Tags []string
+		// ResponseWindow is the number of blocks a request of the job is expected to be fulfilled in. 0 uses the
+		// deadline of the request.
+		ResponseWindow uint64
+	}
+
+	// JobRegistry maps spec IDs to jobs. The jobs are configured statically and optionally pulled from the API of
+	// a Chainlink node. Static jobs take precedence.
+	JobRegistry struct {
+		static map[string]JobInfo
+		remote map[string]JobInfo
+		api    *JobAPI
+		lock   sync.RWMutex
+
+		infoGauge *prometheus.GaugeVec
+	}
+
+	// JobAPI pulls the job specs from the API of a Chainlink node.
+	JobAPI struct {
+		url      string
+		email    string
+		password string
+		client   *http.Client
+	}
+
+	// jobSpecsResponse is the JSON API document returned by the specs endpoint
+	jobSpecsResponse struct {
+		Data []struct {
+			ID         string `json:"id"`
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+)
+
+// NewJobRegistry creates a registry of the given jobs by spec ID. api is optional. The metrics of the registry are
+// registered with registerer.
+func NewJobRegistry(jobs map[string]JobInfo, api *JobAPI, registerer prometheus.Registerer) *JobRegistry {
+	r := &JobRegistry{
+		static: map[string]JobInfo{},
+		remote: map[string]JobInfo{},
+		api:    api,
+		infoGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "job_info",
+			Help: "Always 1, with the name and tags of the job of a spec ID",
+		}, []string{"spec_id", "job_name", "tags"}),
+	}
+	for specID, job := range jobs {
+		r.static[normalizeSpecID(specID)] = job
+	}
+	registerer.MustRegister(r.infoGauge)
+	r.updateMetrics()
+
+	return r
+}
+
+func NewJobAPI(url, email, password string) (*JobAPI, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JobAPI{
+		url:      strings.TrimSuffix(url, "/"),
+		email:    email,
+		password: password,
+		client:   &http.Client{Jar: jar, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Job returns the job of a sanitized spec ID. A nil registry knows no jobs.
+func (r *JobRegistry) Job(specID string) (JobInfo, bool) {
+	if r == nil {
+		return JobInfo{}, false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	specID = normalizeSpecID(specID)
+	job, ok := r.static[specID]
+	if remote, found := r.remote[specID]; found {
+		if !ok {
+			return remote, true
+		}
+		if job.Name == "" {
+			job.Name = remote.Name
+		}
+	}
+
+	return job, ok
+}
+
+// Refresh pulls the jobs from the node API once. It does nothing if no API is configured. Job names are resolved
+// when a request is first seen, so the jobs should be pulled before the monitors start.
+func (r *JobRegistry) Refresh() {
+	if r.api == nil {
+		return
+	}
+
+	jobs, err := r.api.Jobs()
+	if err != nil {
+		zap.L().Error("failed to fetch jobs", zap.Error(err))
+		return
+	}
+	r.lock.Lock()
+	r.remote = jobs
+	r.lock.Unlock()
+	r.updateMetrics()
+	zap.L().Debug("fetched jobs", zap.Int("jobs", len(jobs)))
+}
+
+// Run pulls the jobs from the node API periodically, starting after the refresh interval. It returns immediately
+// if no API is configured.
+func (r *JobRegistry) Run() {
+	if r.api == nil {
+		return
+	}
+
+	zap.L().Info("Starting job routine")
+	for {
+		time.Sleep(jobRefreshInterval)
+		r.Refresh()
+	}
+}
+
+// updateMetrics exports the info metric of all known jobs.
+func (r *JobRegistry) updateMetrics() {
+	r.lock.RLock()
+	specIDs := map[string]bool{}
+	for specID := range r.static {
+		specIDs[specID] = true
+	}
+	for specID := range r.remote {
+		specIDs[specID] = true
+	}
+	r.lock.RUnlock()
+
+	r.infoGauge.Reset()
+	for specID := range specIDs {
+		job, _ := r.Job(specID)
+		r.infoGauge.WithLabelValues(specID, job.Name, strings.Join(job.Tags, ",")).Set(1)
+	}
+}
+
+// Jobs returns the jobs of the node by spec ID. It logs in if the session is missing or expired.
+func (a *JobAPI) Jobs() (map[string]JobInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	specs, err := a.fetchSpecs(ctx)
+	if err == errUnauthorized {
+		if err := a.login(ctx); err != nil {
+			return nil, err
+		}
+		specs, err = a.fetchSpecs(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := map[string]JobInfo{}
+	for _, spec := range specs.Data {
+		jobs[normalizeSpecID(spec.ID)] = JobInfo{Name: spec.Attributes.Name}
+	}
+
+	return jobs, nil
+}
+
+func (a *JobAPI) fetchSpecs(ctx context.Context) (*jobSpecsResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v2/specs?size=%d", a.url, jobPageSize), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch job specs: %s", resp.Status)
+	}
+
+	specs := &jobSpecsResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(specs); err != nil {
+		return nil, fmt.Errorf("failed to decode job specs: %w", err)
+	}
+
+	return specs, nil
+}
+
+func (a *JobAPI) login(ctx context.Context) error {
+	body, err := json.Marshal(map[string]string{"email": a.email, "password": a.password})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.url+"/sessions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to log in to the node API: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// normalizeSpecID converts a spec ID to the form it has in requests. Job IDs are UUIDs whose dashes are
+// stripped on-chain.
+func normalizeSpecID(specID string) string {
+	return strings.Replace(specID, "-", "", -1)
+}
@@ -1,62 +1,90 @@
 package main
 
 import (
-	"context"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	_ "net/http/pprof"
 )
 
 var (
-	oracleAddr      = os.Getenv("ADDRESS")
-	fulfillmentAddr = os.Getenv("NODE_ADDRESS")
-	linkAddr        = os.Getenv("LINK_ADDRESS")
-	rpcHost         = os.Getenv("RPC")
-	lAddr           = os.Getenv("LADDR")
+	configPath = os.Getenv("CONFIG")
 )
 
 func main() {
 	l, _ := zap.NewProduction()
 	zap.ReplaceGlobals(l)
 
-	if lAddr == "" {
-		panic("LADDR must be set")
+	var (
+		cfg *Config
+		err error
+	)
+	if configPath != "" {
+		cfg, err = LoadConfig(configPath)
+	} else {
+		cfg, err = ConfigFromEnv()
 	}
-	if rpcHost == "" {
-		panic("RPC must be set")
-	}
-	if fulfillmentAddr == "" {
-		panic("NODE_ADDRESS must be set")
-	}
-	if oracleAddr == "" {
-		panic("ADDRESS must be set")
-	}
-	if linkAddr == "" {
-		zap.L().Warn("LINK_ADDRESS isn't set. Falling back to mainnet default.")
-		linkAddr = "0x514910771af9ca656af840dff83e8264ecf986ca"
+	if err != nil {
+		panic(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-	defer cancel()
-	c, err := ethclient.DialContext(ctx, rpcHost)
+	// Metrics that don't belong to a monitor
+	registry := prometheus.NewRegistry()
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+
+	c, err := NewRPCPool(cfg.Endpoints(), cfg.MaxHeadLag(), cfg.Registerer(registry))
 	if err != nil {
 		panic(err)
 	}
+	c.Start()
 
-	mon, err := NewMonitor(common.HexToAddress(oracleAddr), common.HexToAddress(fulfillmentAddr), common.HexToAddress(linkAddr), c)
+	jobs, err := cfg.JobRegistry(cfg.Registerer(registry))
 	if err != nil {
 		panic(err)
 	}
-	mon.Start()
+	jobs.Refresh()
+	go jobs.Run()
+
+	monitors := make([]*Monitor, 0, len(cfg.Oracles))
+	for i := range cfg.Oracles {
+		monCfg := cfg.MonitorConfig(i)
+		monCfg.Jobs = jobs
+		mon, err := NewMonitor(c, monCfg)
+		if err != nil {
+			panic(err)
+		}
+		if err := mon.Start(); err != nil {
+			panic(err)
+		}
+		monitors = append(monitors, mon)
+		gatherers = append(gatherers, mon.Registry())
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		zap.L().Info("shutting down", zap.String("signal", sig.String()))
+		for _, mon := range monitors {
+			if err := mon.Close(); err != nil {
+				zap.L().Error("failed to close store", zap.Error(err))
+			}
+		}
+		os.Exit(0)
+	}()
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{
+			// A failing registry must not hide the metrics of the others
+			ErrorLog:      zap.NewStdLog(zap.L()),
+			ErrorHandling: promhttp.ContinueOnError,
+		})))
 
-	panic(http.ListenAndServe(lAddr, nil))
+	panic(http.ListenAndServe(cfg.ListenAddr, nil))
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+)
+
+const (
+	// DefaultMetricsNamespace and DefaultMetricsSubsystem form the default prefix of all metric names
+	DefaultMetricsNamespace = "cl"
+	DefaultMetricsSubsystem = "mon"
+)
+
+var (
+	// DefaultBuckets are the buckets of the histograms by metric name
+	DefaultBuckets = map[string][]float64{
+		"response_time":         {1, 2, 3, 4, 5, 10, 15},
+		"response_time_seconds": {5, 10, 15, 30, 60, 120, 300, 600},
+		"fulfillment_gas_used":  {50000, 75000, 100000, 150000, 200000, 300000, 500000, 1000000},
+		"answer_deviation":      prometheus.ExponentialBuckets(1, 10, 16),
+		"answer_deviation_bps":  {0, 1, 5, 10, 25, 50, 100, 250, 500, 1000},
+		"response_rank":         {1, 2, 3, 4, 5, 7, 10, 15, 20, 30, 45},
+		"reorg_depth":           {1, 2, 3, 4, 5, 10, 20},
+	}
+
+	// variableLabels are the names of the labels the metrics are partitioned by, besides the request parameter
+	// labels. They can't be used as constant labels.
+	variableLabels = []string{"aggregator", "aggregator_oracle", "change", "endpoint", "job_id", "job_name", "node",
+		"owner", "recipient", "requester", "sender", "spec_id", "status", "tags", "type", "window"}
+)
+
+// MetricPrefix returns the prefix of the metric names with the given namespace and subsystem. Both are optional.
+func MetricPrefix(namespace, subsystem string) string {
+	var parts []string
+	for _, part := range []string{namespace, subsystem} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return strings.Join(parts, "_") + "_"
+}
+
+// validName reports whether s can be used as label name or as part of a metric name.
+func validName(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Registry returns the registry of the metrics of the monitor.
+func (m *Monitor) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// buckets returns the configured buckets of a histogram.
+func (c MonitorConfig) buckets(name string) []float64 {
+	if buckets, ok := c.Buckets[name]; ok {
+		return buckets
+	}
+
+	return DefaultBuckets[name]
+}
@@ -4,12 +4,12 @@ import (
 	"chainlink_exporter/abi"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
@@ -21,119 +21,481 @@ import (
 
 const (
 	PRECISION = 1000000000
+
+	// DefaultConfirmations is the default number of blocks an outcome needs to be buried by before it is recorded.
+	DefaultConfirmations = 12
+	// DefaultDeadlineBlocks is the number of blocks after which a request without a cancel expiration is missed.
+	DefaultDeadlineBlocks = 15
+
+	// requesterCheckAttempts is the number of times a requester is checked for being an aggregator before it is
+	// treated as a consumer
+	requesterCheckAttempts = 5
+	// requesterCheckDelay is the delay before a failed check is retried. It doubles with every attempt.
+	requesterCheckDelay = time.Second
 )
 
 type (
+	MonitorConfig struct {
+		// Address is the address of the oracle contract
+		Address common.Address
+		// Nodes are the addresses that fulfill requests to the oracle
+		Nodes []common.Address
+		// LinkAddress is the address of the LINK token contract
+		LinkAddress common.Address
+		// Labels are added to all metrics of the monitor in addition to the oracle address
+		Labels map[string]string
+
+		// BackfillBlocks is the number of past blocks to replay on startup. 0 disables the backfill.
+		BackfillBlocks uint64
+		// StorePath is the directory of the state store. The state isn't persisted if it's empty.
+		StorePath string
+		// Confirmations is the number of blocks a fulfillment or miss needs to be buried by before it is recorded.
+		// Outcomes in younger blocks can still be reverted by a chain reorganization.
+		Confirmations uint64
+		// DeadlineBlocks overrides the deadline of requests by sanitized spec ID with a fixed number of blocks.
+		DeadlineBlocks map[string]uint64
+		// CancelWindowBlocks is the number of blocks after the declaration of a miss in which the requester can
+		// still cancel the request. The miss is only recorded afterwards, a cancellation within the window is
+		// recorded as such. 0 records misses right away.
+		CancelWindowBlocks uint64
+		// PollInterval enables polling for new blocks and logs instead of subscribing to them. 0 uses subscriptions.
+		PollInterval time.Duration
+		// ParamLabels are the request parameters that are added as labels to the fulfilled, missed and response time
+		// metrics
+		ParamLabels []string
+		// Jobs maps spec IDs to jobs. It is optional.
+		Jobs *JobRegistry
+		// MetricPrefix is prepended to the names of all metrics
+		MetricPrefix string
+		// Buckets are the histogram buckets by metric name. Histograms that aren't configured use DefaultBuckets.
+		Buckets map[string][]float64
+		// SpecResponseTimeSecondsBuckets are the buckets of the response time in seconds of specs with own buckets
+		SpecResponseTimeSecondsBuckets map[string][]float64
+
+		// LinkEthPrice is a static LINK/ETH price. Profits aren't calculated if neither it nor LinkEthFeed is set.
+		LinkEthPrice float64
+		// LinkEthFeed is the address of a LINK/ETH price feed aggregator. It takes precedence over LinkEthPrice.
+		LinkEthFeed         *common.Address
+		LinkEthFeedDecimals uint8
+	}
+
 	Monitor struct {
-		client      *ethclient.Client
+		cfg MonitorConfig
+		// constLabels are the labels of all metrics of the monitor
+		constLabels prometheus.Labels
+		// registry holds the metrics of the monitor
+		registry   *prometheus.Registry
+		client     EthClient
+		store      *Store
+		chain      *ChainTracker
+		scanner    *FulfillmentScanner
+		dispatcher *LogDispatcher
+		price      PriceSource
+		blockTimes *BlockTimeCache
+		// spend estimates the runway of every node address
+		spend       map[common.Address]*SpendEstimator
+		nonces      map[common.Address]*NonceTracker
 		aggregators map[common.Address]*AggregatorMonitor
+		// costs queues the fulfillments whose gas costs are yet to be fetched
+		costs chan costJob
 
-		addr            common.Address
-		fulfillmentAddr common.Address
-		oracle          *abi.Oracle
-		linkContract    *abi.ERC
+		addr             common.Address
+		fulfillmentAddrs []common.Address
+		oracle           *abi.Oracle
+		linkContract     *abi.ERC
 
 		lock sync.Mutex
 
 		lastResTime *atomic.Uint64
 		lastReqTime *atomic.Uint64
+		backfilling *atomic.Bool
+		// updatingBalances is set while the balances of the oracle and the node addresses are fetched
+		updatingBalances *atomic.Bool
+		// updatingNonces is set while the nonces of the node addresses are fetched
+		updatingNonces *atomic.Bool
+		// updatingPermissions is set while the owner and the node authorizations are fetched
+		updatingPermissions *atomic.Bool
+		// owner and authorized are the last known owner of the oracle and authorizations of the node addresses
+		owner      *common.Address
+		authorized map[common.Address]bool
+		// historicalHeight is the head the backfill started at. Ownership logs up to it aren't reported as changes.
+		historicalHeight *atomic.Uint64
+		// ownershipLogs are the ownership logs that have been handled, so redelivered logs are only reported once
+		ownershipLogs map[logKey]bool
+		ownershipLock sync.Mutex
+
+		// transfers are the unconfirmed LINK transfers of the oracle, transfersConfirmed the height they are
+		// recorded up to
+		transfers          map[logKey]*abi.ERCTransfer
+		transfersConfirmed uint64
+		transferLock       sync.Mutex
+
+		lastResGauge       prometheus.Gauge
+		lastReqGauge       prometheus.Gauge
+		currentHeightGauge prometheus.Gauge
+		unconfirmedGauge   *prometheus.GaugeVec
+		balanceGauge       *prometheus.GaugeVec
+		runwaySecondsGauge *prometheus.GaugeVec
+		runwayFulfillGauge *prometheus.GaugeVec
+		nonceGapGauge      *prometheus.GaugeVec
+		pendingAgeGauge    *prometheus.GaugeVec
+		droppedTxCounter   *prometheus.CounterVec
+		linkBalanceGauge   *prometheus.GaugeVec
+		linkInCounter      *prometheus.CounterVec
+		linkOutCounter     *prometheus.CounterVec
 
-		lastResGauge          prometheus.Gauge
-		lastReqGauge          prometheus.Gauge
-		currentHeightGauge    prometheus.Gauge
-		balanceGauge          prometheus.Gauge
-		linkBalanceGauge      *prometheus.GaugeVec
-		responseTimeHistogram *prometheus.HistogramVec
+		ownerInfoGauge          *prometheus.GaugeVec
+		nodeAuthorizedGauge     *prometheus.GaugeVec
+		permissionChangeCounter *prometheus.CounterVec
 
-		revenueCounter     *prometheus.CounterVec
-		fulfillmentCounter *prometheus.CounterVec
-		missCounter        *prometheus.CounterVec
+		responseTimeHistogram        *prometheus.HistogramVec
+		responseTimeSecondsHistogram *SpecHistogramVec
+
+		answerGauge        *prometheus.GaugeVec
+		roundGauge         *prometheus.GaugeVec
+		startedRoundGauge  *prometheus.GaugeVec
+		answerAgeGauge     *prometheus.GaugeVec
+		roundIntervalGauge *prometheus.GaugeVec
+
+		deviationHistogram    *prometheus.HistogramVec
+		deviationBpsHistogram *prometheus.HistogramVec
+		rankHistogram         *prometheus.HistogramVec
+		lateCounter           *prometheus.CounterVec
+
+		paymentGauge          *prometheus.GaugeVec
+		minimumResponsesGauge *prometheus.GaugeVec
+		oraclesGauge          *prometheus.GaugeVec
+		oracleInfoGauge       *prometheus.GaugeVec
+		configChangeCounter   *prometheus.CounterVec
+
+		revenueCounter       *prometheus.CounterVec
+		gasSpentCounter      *prometheus.CounterVec
+		gasUsedHistogram     *prometheus.HistogramVec
+		profitLinkGauge      *prometheus.GaugeVec
+		profitEthGauge       *prometheus.GaugeVec
+		profitSkippedCounter *prometheus.CounterVec
+		fulfillmentCounter   *prometheus.CounterVec
+		missCounter          *prometheus.CounterVec
+		cancelCounter        *prometheus.CounterVec
+		reorgCounter         prometheus.Counter
+		reorgDepthHistogram  prometheus.Histogram
 	}
 )
 
-func NewMonitor(addr common.Address, fulfillmentAddr common.Address, linkAddr common.Address, client *ethclient.Client) (*Monitor, error) {
+func NewMonitor(client EthClient, cfg MonitorConfig) (*Monitor, error) {
+	constLabels := prometheus.Labels{"oracle": cfg.Address.String()}
+	for name, value := range cfg.Labels {
+		constLabels[name] = value
+	}
+
+	paramLabels := paramLabelNames(cfg.ParamLabels)
+
 	m := &Monitor{
-		addr:            addr,
-		fulfillmentAddr: fulfillmentAddr,
-		client:          client,
-		aggregators:     map[common.Address]*AggregatorMonitor{},
-		lock:            sync.Mutex{},
-		lastResTime:     atomic.NewUint64(0),
-		lastReqTime:     atomic.NewUint64(0),
+		cfg:                 cfg,
+		constLabels:         constLabels,
+		registry:            prometheus.NewRegistry(),
+		addr:                cfg.Address,
+		fulfillmentAddrs:    cfg.Nodes,
+		client:              client,
+		chain:               NewChainTracker(client),
+		dispatcher:          NewLogDispatcher(client),
+		blockTimes:          NewBlockTimeCache(client),
+		aggregators:         map[common.Address]*AggregatorMonitor{},
+		spend:               map[common.Address]*SpendEstimator{},
+		nonces:              map[common.Address]*NonceTracker{},
+		costs:               make(chan costJob, costQueueSize),
+		transfers:           map[logKey]*abi.ERCTransfer{},
+		lock:                sync.Mutex{},
+		lastResTime:         atomic.NewUint64(0),
+		lastReqTime:         atomic.NewUint64(0),
+		backfilling:         atomic.NewBool(false),
+		updatingBalances:    atomic.NewBool(false),
+		updatingNonces:      atomic.NewBool(false),
+		updatingPermissions: atomic.NewBool(false),
+		authorized:          map[common.Address]bool{},
+		historicalHeight:    atomic.NewUint64(0),
+		ownershipLogs:       map[logKey]bool{},
 		lastResGauge: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "last_response",
-			Help:      "Height of the last response",
+			ConstLabels: constLabels,
+			Name:        "last_response",
+			Help:        "Height of the last response",
 		}),
 		lastReqGauge: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "last_request",
-			Help:      "Height of the last oracle request",
+			ConstLabels: constLabels,
+			Name:        "last_request",
+			Help:        "Height of the last oracle request",
 		}),
 		currentHeightGauge: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "height",
-			Help:      "Last synced height",
+			ConstLabels: constLabels,
+			Name:        "height",
+			Help:        "Last synced height",
 		}),
+		unconfirmedGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "unconfirmed",
+			Help:        "Number of fulfillments, cancellations and misses waiting for confirmations",
+		}, []string{"status"}),
 		responseTimeHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "response_time",
-			Help:      "Average response time in blocks",
-			Buckets:   []float64{1, 2, 3, 4, 5, 10, 15},
-		}, []string{"spec_id"}),
+			ConstLabels: constLabels,
+			Name:        "response_time",
+			Help:        "Average response time in blocks",
+			Buckets:     cfg.buckets("response_time"),
+		}, append([]string{"spec_id", "job_name"}, paramLabels...)),
+		responseTimeSecondsHistogram: NewSpecHistogramVec(prometheus.HistogramOpts{
+			ConstLabels: constLabels,
+			Name:        "response_time_seconds",
+			Help:        "Seconds between the blocks of requests and their fulfillments",
+			Buckets:     cfg.buckets("response_time_seconds"),
+		}, cfg.SpecResponseTimeSecondsBuckets, append([]string{"job_name"}, paramLabels...)),
 		fulfillmentCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "fulfilled",
-			Help:      "Number of successfully fulfilled requests",
-		}, []string{"spec_id", "requester"}),
+			ConstLabels: constLabels,
+			Name:        "fulfilled",
+			Help:        "Number of successfully fulfilled requests",
+		}, append([]string{"spec_id", "job_name", "requester"}, paramLabels...)),
 		missCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "missed",
-			Help:      "Number of missed requests",
+			ConstLabels: constLabels,
+			Name:        "missed",
+			Help:        "Number of missed requests",
+		}, append([]string{"spec_id", "job_name", "requester"}, paramLabels...)),
+		cancelCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "cancelled",
+			Help:        "Number of cancelled requests",
 		}, []string{"spec_id", "requester"}),
 		revenueCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "revenue",
-			Help:      "Number of LINK tokens earned",
-		}, []string{"spec_id", "requester", "status"}),
-		balanceGauge: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "eth_balance",
-			Help:      "Balance of the oracle account",
-		}),
+			ConstLabels: constLabels,
+			Name:        "revenue",
+			Help:        "Number of LINK tokens earned",
+		}, []string{"spec_id", "job_name", "requester", "status"}),
+		gasSpentCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "gas_spent_eth",
+			Help:        "ETH spent on gas for fulfillments",
+		}, []string{"spec_id", "requester"}),
+		gasUsedHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			ConstLabels: constLabels,
+			Name:        "fulfillment_gas_used",
+			Help:        "Gas used by fulfillment transactions",
+			Buckets:     cfg.buckets("fulfillment_gas_used"),
+		}, []string{"spec_id", "requester"}),
+		profitLinkGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "profit_link",
+			Help:        "Revenue of fulfillments minus their gas costs in LINK",
+		}, []string{"spec_id", "requester"}),
+		profitEthGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "profit_eth",
+			Help:        "Revenue of fulfillments minus their gas costs in ETH",
+		}, []string{"spec_id", "requester"}),
+		profitSkippedCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "profit_skipped",
+			Help:        "Fulfillments whose profit wasn't recorded because the LINK/ETH price was unavailable",
+		}, []string{"spec_id", "requester"}),
+		balanceGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "eth_balance",
+			Help:        "Balance of the oracle account",
+		}, []string{"node"}),
+		runwaySecondsGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "eth_runway_seconds",
+			Help:        "Estimated seconds until the node account runs out of ETH at the spending of the window",
+		}, []string{"node", "window"}),
+		runwayFulfillGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "eth_runway_fulfillments",
+			Help:        "Estimated number of fulfillments the ETH balance of the node account pays for at the costs of the window",
+		}, []string{"node", "window"}),
+		nonceGapGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "nonce_gap",
+			Help:        "Number of pending transactions of the node account",
+		}, []string{"node"}),
+		pendingAgeGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "pending_tx_age_seconds",
+			Help:        "Seconds the oldest pending transaction of the node account has been pending",
+		}, []string{"node"}),
+		droppedTxCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "dropped_transactions_total",
+			Help:        "Number of pending transactions of the node account that were replaced or dropped without being mined",
+		}, []string{"node"}),
 		linkBalanceGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: "cl",
-			Subsystem: "mon",
-			Name:      "link_balance",
-			Help:      "Link balance of the oracle",
+			ConstLabels: constLabels,
+			Name:        "link_balance",
+			Help:        "Link balance of the oracle",
 		}, []string{"type"}),
+		linkInCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "link_transferred_in",
+			Help:        "LINK transferred to the oracle",
+		}, []string{"sender"}),
+		linkOutCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "link_transferred_out",
+			Help:        "LINK transferred out of the oracle",
+		}, []string{"recipient"}),
+		ownerInfoGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "oracle_owner_info",
+			Help:        "Owner of the oracle",
+		}, []string{"owner"}),
+		nodeAuthorizedGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "node_authorized",
+			Help:        "Whether the node account is authorized to fulfill requests of the oracle",
+		}, []string{"node"}),
+		permissionChangeCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "oracle_permission_changes_total",
+			Help:        "Number of detected changes of the oracle owner or the node authorizations",
+		}, []string{"change"}),
+		answerGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_answer",
+			Help:        "Latest answer of the aggregator",
+		}, []string{"aggregator"}),
+		roundGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_round",
+			Help:        "Round ID of the latest answer of the aggregator",
+		}, []string{"aggregator"}),
+		startedRoundGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_started_round",
+			Help:        "Round ID of the latest round started on the aggregator",
+		}, []string{"aggregator"}),
+		answerAgeGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_answer_age_seconds",
+			Help:        "Seconds since the latest answer of the aggregator was updated",
+		}, []string{"aggregator"}),
+		roundIntervalGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_round_interval_seconds",
+			Help:        "Seconds between the latest answer of the aggregator and the answer of the previous round",
+		}, []string{"aggregator"}),
+		deviationHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			ConstLabels: constLabels,
+			Name:        "answer_deviation",
+			Help:        "Absolute deviation of the oracle's response from the aggregated answer of the round",
+			Buckets:     cfg.buckets("answer_deviation"),
+		}, []string{"aggregator"}),
+		deviationBpsHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			ConstLabels: constLabels,
+			Name:        "answer_deviation_bps",
+			Help:        "Deviation of the oracle's response from the aggregated answer of the round in basis points",
+			Buckets:     cfg.buckets("answer_deviation_bps"),
+		}, []string{"aggregator"}),
+		rankHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			ConstLabels: constLabels,
+			Name:        "response_rank",
+			Help:        "Position of the oracle's response among all responses to the round",
+			Buckets:     cfg.buckets("response_rank"),
+		}, []string{"aggregator"}),
+		lateCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "late_responses",
+			Help:        "Number of rounds the oracle responded to after the minimum number of responses was reached",
+		}, []string{"aggregator"}),
+		paymentGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_payment_amount",
+			Help:        "LINK paid by the aggregator per response",
+		}, []string{"aggregator"}),
+		minimumResponsesGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_minimum_responses",
+			Help:        "Number of responses the aggregator needs to update its answer",
+		}, []string{"aggregator"}),
+		oraclesGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_oracles",
+			Help:        "Number of oracle jobs the aggregator requests answers from",
+		}, []string{"aggregator"}),
+		oracleInfoGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_oracle_info",
+			Help:        "Oracles and job IDs the aggregator requests answers from",
+		}, []string{"aggregator", "aggregator_oracle", "job_id"}),
+		configChangeCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "aggregator_config_changes_total",
+			Help:        "Number of aggregator configuration changes affecting the oracle",
+		}, []string{"aggregator", "change"}),
+		reorgCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			ConstLabels: constLabels,
+			Name:        "reorgs_total",
+			Help:        "Number of chain reorganizations",
+		}),
+		reorgDepthHistogram: prometheus.NewHistogram(prometheus.HistogramOpts{
+			ConstLabels: constLabels,
+			Name:        "reorg_depth",
+			Help:        "Number of blocks orphaned by chain reorganizations",
+			Buckets:     cfg.buckets("reorg_depth"),
+		}),
 	}
 
-	prometheus.MustRegister(m.lastResGauge)
-	prometheus.MustRegister(m.lastReqGauge)
-	prometheus.MustRegister(m.currentHeightGauge)
-	prometheus.MustRegister(m.responseTimeHistogram)
-	prometheus.MustRegister(m.fulfillmentCounter)
-	prometheus.MustRegister(m.revenueCounter)
-	prometheus.MustRegister(m.missCounter)
-	prometheus.MustRegister(m.balanceGauge)
-	prometheus.MustRegister(m.linkBalanceGauge)
+	for _, node := range cfg.Nodes {
+		m.spend[node] = NewSpendEstimator()
+		m.nonces[node] = NewNonceTracker()
+	}
+
+	registerer := prometheus.WrapRegistererWithPrefix(cfg.MetricPrefix, m.registry)
+	registerer.MustRegister(m.lastResGauge)
+	registerer.MustRegister(m.lastReqGauge)
+	registerer.MustRegister(m.currentHeightGauge)
+	registerer.MustRegister(m.unconfirmedGauge)
+	registerer.MustRegister(m.responseTimeHistogram)
+	registerer.MustRegister(m.responseTimeSecondsHistogram)
+	registerer.MustRegister(m.fulfillmentCounter)
+	registerer.MustRegister(m.revenueCounter)
+	registerer.MustRegister(m.missCounter)
+	registerer.MustRegister(m.cancelCounter)
+	registerer.MustRegister(m.gasSpentCounter)
+	registerer.MustRegister(m.gasUsedHistogram)
+	registerer.MustRegister(m.profitLinkGauge)
+	registerer.MustRegister(m.profitEthGauge)
+	registerer.MustRegister(m.profitSkippedCounter)
+	registerer.MustRegister(m.balanceGauge)
+	registerer.MustRegister(m.linkBalanceGauge)
+	registerer.MustRegister(m.linkInCounter)
+	registerer.MustRegister(m.linkOutCounter)
+	registerer.MustRegister(m.ownerInfoGauge)
+	registerer.MustRegister(m.nodeAuthorizedGauge)
+	registerer.MustRegister(m.permissionChangeCounter)
+	registerer.MustRegister(m.runwaySecondsGauge)
+	registerer.MustRegister(m.runwayFulfillGauge)
+	registerer.MustRegister(m.nonceGapGauge)
+	registerer.MustRegister(m.pendingAgeGauge)
+	registerer.MustRegister(m.droppedTxCounter)
+	registerer.MustRegister(m.answerGauge)
+	registerer.MustRegister(m.roundGauge)
+	registerer.MustRegister(m.startedRoundGauge)
+	registerer.MustRegister(m.answerAgeGauge)
+	registerer.MustRegister(m.roundIntervalGauge)
+	registerer.MustRegister(m.deviationHistogram)
+	registerer.MustRegister(m.deviationBpsHistogram)
+	registerer.MustRegister(m.rankHistogram)
+	registerer.MustRegister(m.lateCounter)
+	registerer.MustRegister(m.paymentGauge)
+	registerer.MustRegister(m.minimumResponsesGauge)
+	registerer.MustRegister(m.oraclesGauge)
+	registerer.MustRegister(m.oracleInfoGauge)
+	registerer.MustRegister(m.configChangeCounter)
+	registerer.MustRegister(m.reorgCounter)
+	registerer.MustRegister(m.reorgDepthHistogram)
 
-	oracle, err := abi.NewOracle(addr, m.client)
+	oracle, err := abi.NewOracle(cfg.Address, m.client)
 	if err != nil {
 		return nil, err
 	}
 	m.oracle = oracle
 
-	linkContract, err := abi.NewERC(linkAddr, m.client)
+	linkContract, err := abi.NewERC(cfg.LinkAddress, m.client)
 	if err != nil {
 		return nil, err
 	}
@@ -144,13 +506,69 @@ func NewMonitor(addr common.Address, fulfillmentAddr common.Address, linkAddr co
 		return nil, fmt.Errorf("address does not belong to an oracle")
 	}
 
+	m.scanner, err = NewFulfillmentScanner(client, cfg.Address, cfg.Nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dispatcher.Register(cfg.Address, ownershipTransferredTopic, m.handleOwnershipTransferredLog)
+	m.dispatcher.Register(cfg.Address, ownershipRenouncedTopic, m.handleOwnershipRenouncedLog)
+	for _, query := range m.transferQueries() {
+		m.dispatcher.RegisterFilter(query, m.handleTransferLog)
+	}
+
+	if cfg.LinkEthFeed != nil {
+		m.price, err = NewFeedPrice(client, *cfg.LinkEthFeed, cfg.LinkEthFeedDecimals)
+		if err != nil {
+			return nil, err
+		}
+	} else if cfg.LinkEthPrice > 0 {
+		m.price = StaticPrice(cfg.LinkEthPrice)
+	}
+
+	if cfg.StorePath != "" {
+		m.store, err = OpenStore(cfg.StorePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return m, nil
 }
 
-func (m *Monitor) Start() {
-	go m.headRoutine()
-	go m.requestRoutine()
+// Start restores the state from the store and starts the routines of the monitor. It fails if the state can't be
+// restored, since the first checkpoint would overwrite it.
+func (m *Monitor) Start() error {
+	var checkpoint *uint64
+	if m.store != nil {
+		height, ok, err := m.restore()
+		if err != nil {
+			return fmt.Errorf("failed to restore state: %w", err)
+		}
+		if ok {
+			checkpoint = &height
+		}
+	}
+
+	if m.cfg.BackfillBlocks > 0 || checkpoint != nil {
+		m.backfilling.Store(true)
+		go m.backfill(checkpoint)
+	}
+
+	if m.cfg.PollInterval > 0 {
+		go m.pollRoutine()
+	} else {
+		go m.headRoutine()
+		go m.requestRoutine()
+		go m.cancelRoutine()
+		go m.dispatcher.Run()
+	}
 	go m.metricRoutine()
+	go m.configRoutine()
+	go m.scanRoutine()
+	go m.costRoutine()
+
+	return nil
 }
 
 func (m *Monitor) metricRoutine() {
@@ -163,38 +581,85 @@ func (m *Monitor) metricRoutine() {
 		case <-ticker.C:
 			m.lastResGauge.Set(float64(m.lastResTime.Load()))
 			m.lastReqGauge.Set(float64(m.lastReqTime.Load()))
+
+			var fulfilled, cancelled, missed int
+			for _, monitor := range m.aggregatorMonitors() {
+				f, c, mi := monitor.unconfirmed()
+				fulfilled += f
+				cancelled += c
+				missed += mi
+
+				if monitor.aggregator != nil {
+					m.updateRoundMetrics(monitor)
+				}
+			}
+			m.unconfirmedGauge.WithLabelValues("fulfilled").Set(float64(fulfilled))
+			m.unconfirmedGauge.WithLabelValues("cancelled").Set(float64(cancelled))
+			m.unconfirmedGauge.WithLabelValues("missed").Set(float64(missed))
+		}
+	}
+}
+
+// updateRoundMetrics exports the latest answer and round of an aggregator.
+func (m *Monitor) updateRoundMetrics(monitor *AggregatorMonitor) {
+	round := monitor.latestRound()
+	if round.roundID == nil {
+		return
+	}
+
+	aggregator := monitor.address.String()
+	answer, _ := new(big.Float).SetInt(round.answer).Float64()
+	m.answerGauge.WithLabelValues(aggregator).Set(answer)
+	m.roundGauge.WithLabelValues(aggregator).Set(float64(round.roundID.Uint64()))
+	m.startedRoundGauge.WithLabelValues(aggregator).Set(float64(round.startedRound.Uint64()))
+	if round.timestamp > 0 {
+		m.answerAgeGauge.WithLabelValues(aggregator).Set(time.Since(time.Unix(int64(round.timestamp), 0)).Seconds())
+	}
+	m.roundIntervalGauge.WithLabelValues(aggregator).Set(float64(round.interval))
+}
+
+// updateRunway records the balance of a node and exports its estimated runway.
+func (m *Monitor) updateRunway(node common.Address, balance float64) {
+	now := time.Now()
+	estimator := m.spend[node]
+	estimator.ObserveBalance(balance, now)
+
+	for _, window := range runwayWindows {
+		seconds, fulfillments, ok := estimator.Runway(window.length, now)
+		if !ok {
+			continue
 		}
+		m.runwaySecondsGauge.WithLabelValues(node.String(), window.name).Set(seconds)
+		m.runwayFulfillGauge.WithLabelValues(node.String(), window.name).Set(fulfillments)
 	}
 }
 
 func (m *Monitor) updateBalances() {
+	if !m.updatingBalances.CAS(false, true) {
+		return
+	}
+	defer m.updatingBalances.Store(false)
+
 	zap.L().Debug("fetching balances")
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
 
-	balance, err := m.client.BalanceAt(ctx, m.fulfillmentAddr, nil)
-	if err != nil {
-		zap.L().Error("failed to fetch oracle balance", zap.Error(err))
-		return
-	}
+	for _, node := range m.fulfillmentAddrs {
+		balance, err := m.client.BalanceAt(ctx, node, nil)
+		if err != nil {
+			zap.L().Error("failed to fetch oracle balance", zap.Error(err), zap.String("node", node.String()))
+			continue
+		}
 
-	balance.Div(balance, big.NewInt(params.Ether/PRECISION))
-	m.balanceGauge.Set(float64(balance.Uint64()) / PRECISION)
+		balance.Div(balance, big.NewInt(params.Ether/PRECISION))
+		m.balanceGauge.WithLabelValues(node.String()).Set(float64(balance.Uint64()) / PRECISION)
+		m.updateRunway(node, float64(balance.Uint64())/PRECISION)
+	}
 
-	owner, err := m.oracle.Owner(&bind.CallOpts{
-		Context: ctx,
-	})
-	withdrawableLinkBalance, err := m.oracle.Withdrawable(&bind.CallOpts{
-		From:    owner,
-		Context: ctx,
-	})
-	if err != nil {
+	if err := m.updateWithdrawable(ctx); err != nil {
 		zap.L().Error("failed to fetch withdrawable LINK balance", zap.Error(err))
-		return
 	}
-	withdrawableLinkBalance.Div(withdrawableLinkBalance, big.NewInt(params.Ether/PRECISION))
-	m.linkBalanceGauge.WithLabelValues("withdrawable").Set(float64(withdrawableLinkBalance.Uint64()) / PRECISION)
 
 	linkBalance, err := m.linkContract.BalanceOf(&bind.CallOpts{Context: ctx}, m.addr)
 	if err != nil {
@@ -207,6 +672,25 @@ func (m *Monitor) updateBalances() {
 	zap.L().Debug("fetched balances")
 }
 
+// updateWithdrawable updates the LINK balance the owner can withdraw from the oracle.
+func (m *Monitor) updateWithdrawable(ctx context.Context) error {
+	owner, err := m.oracle.Owner(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("failed to fetch oracle owner: %w", err)
+	}
+	withdrawableLinkBalance, err := m.oracle.Withdrawable(&bind.CallOpts{
+		From:    owner,
+		Context: ctx,
+	})
+	if err != nil {
+		return err
+	}
+	withdrawableLinkBalance.Div(withdrawableLinkBalance, big.NewInt(params.Ether/PRECISION))
+	m.linkBalanceGauge.WithLabelValues("withdrawable").Set(float64(withdrawableLinkBalance.Uint64()) / PRECISION)
+
+	return nil
+}
+
 func (m *Monitor) headRoutine() {
 	for {
 		zap.L().Info("Starting head routine")
@@ -214,8 +698,8 @@ func (m *Monitor) headRoutine() {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 			defer cancel()
 
-			headChan := make(chan *types.Header, 100)
-			sub, err := m.client.SubscribeNewHead(ctx, headChan)
+			headChan := make(chan *rpcHeader, 100)
+			sub, err := m.client.EthSubscribe(ctx, headChan, "newHeads")
 			if err != nil {
 				zap.L().Error("failed to subscribe to new heads", zap.Error(err))
 				return
@@ -232,14 +716,8 @@ func (m *Monitor) headRoutine() {
 						return
 					}
 
-					// Update balances
-					go m.updateBalances()
-
-					// Update metrics and update aggregator monitors
-					m.currentHeightGauge.Set(float64(header.Number.Uint64()))
-					for _, monitor := range m.aggregators {
-						monitor.HandleNewBlock(header.Number.Uint64())
-					}
+					m.checkReorg(header)
+					m.processHead(header)
 				}
 			}
 		}()
@@ -249,6 +727,76 @@ func (m *Monitor) headRoutine() {
 	}
 }
 
+// checkReorg checks whether header reorganized the chain and reverts the state of the orphaned blocks.
+// It returns the common ancestor if the chain was reorganized.
+func (m *Monitor) checkReorg(header *rpcHeader) (uint64, bool) {
+	ancestor, depth, err := m.chain.HandleHeader(header)
+	if err != nil {
+		zap.L().Error("failed to check for reorg", zap.Error(err))
+		return 0, false
+	}
+	if depth == 0 {
+		return 0, false
+	}
+
+	zap.L().Warn("chain reorganization", zap.Uint64("height", uint64(header.Number)),
+		zap.Uint64("ancestor", ancestor), zap.Uint64("depth", depth))
+	m.reorgCounter.Inc()
+	m.reorgDepthHistogram.Observe(float64(depth))
+	for _, monitor := range m.aggregatorMonitors() {
+		if m.cfg.PollInterval > 0 {
+			// Polled logs aren't removed, the orphaned blocks are polled again instead
+			monitor.Rewind(ancestor)
+		} else {
+			monitor.Rollback(ancestor)
+		}
+	}
+	if m.cfg.PollInterval > 0 {
+		m.rewindTransfers(ancestor)
+	}
+	m.scanner.Rewind(ancestor)
+
+	return ancestor, true
+}
+
+// processHead updates the head metrics and records the outcomes that are due at the new head.
+func (m *Monitor) processHead(header *rpcHeader) {
+	// Update balances and pending transactions
+	go m.updateBalances()
+	go m.updateNonces()
+	go m.updatePermissions()
+
+	// Update metrics and update aggregator monitors
+	m.currentHeightGauge.Set(float64(header.Number))
+	m.blockTimes.Add(header.Hash, uint64(header.Time))
+
+	if m.backfilling.Load() {
+		// Don't declare misses before the backfill had the chance to see the fulfillments
+		return
+	}
+
+	// Requesters that aren't aggregators are updated once the scanner scanned the block
+	m.scanner.Notify(uint64(header.Number))
+	for _, monitor := range m.aggregatorMonitors() {
+		if monitor.aggregator != nil {
+			monitor.HandleNewBlock(uint64(header.Number), uint64(header.Time))
+		}
+	}
+	m.confirmTransfers(uint64(header.Number))
+
+	// Requesters that aren't aggregators are only processed up to the scanned block
+	processed := uint64(header.Number)
+	if scanned := m.scanner.Cursor(); scanned < processed {
+		processed = scanned
+	}
+	if m.store != nil && processed > m.cfg.Confirmations {
+		// Only outcomes up to this height are part of the metrics
+		if err := m.checkpoint(processed - m.cfg.Confirmations); err != nil {
+			zap.L().Error("failed to write checkpoint", zap.Error(err))
+		}
+	}
+}
+
 func (m *Monitor) requestRoutine() {
 	for {
 		zap.L().Info("Starting request routine")
@@ -290,47 +838,349 @@ func (m *Monitor) requestRoutine() {
 	}
 }
 
+func (m *Monitor) cancelRoutine() {
+	for {
+		zap.L().Info("Starting cancel routine")
+		func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			cancelChan := make(chan *abi.OracleCancelOracleRequest, 100)
+			sub, err := m.oracle.WatchCancelOracleRequest(&bind.WatchOpts{
+				Context: ctx,
+			}, cancelChan, nil)
+			if err != nil {
+				zap.L().Error("failed to watch oracle request cancellations", zap.Error(err))
+				return
+			}
+
+			for {
+				select {
+				case err = <-sub.Err():
+					zap.L().Error("oracle request cancellations subscription errored", zap.Error(err))
+					return
+
+				case c, has := <-cancelChan:
+					if !has {
+						zap.L().Error("cancel subscription closed", zap.Error(err))
+						return
+					}
+					m.handleCancellation(c)
+				}
+			}
+		}()
+
+		zap.L().Warn("cancel routine died. restarting in 5sec")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (m *Monitor) handleCancellation(cancel *abi.OracleCancelOracleRequest) {
+	for _, monitor := range m.aggregatorMonitors() {
+		if monitor.handleCancellation(cancel) {
+			return
+		}
+	}
+
+	zap.L().Debug("cancelled request unknown or already recorded", zap.Uint64("height", cancel.Raw.BlockNumber),
+		zap.Binary("request_id", cancel.RequestId[:]))
+}
+
 func (m *Monitor) handleRequest(req *abi.OracleOracleRequest) error {
 	logger := zap.L().With(zap.Uint64("height", req.Raw.BlockNumber),
 		zap.String("requester", req.Requester.String()), zap.Binary("request_id", req.RequestId[:]),
 		zap.String("spec_id", sanitizeSpecID(req.SpecId)))
+	if params, err := decodeRequestParams(req.DataVersion, req.Data); err != nil {
+		logger.Debug("failed to decode request parameters", zap.Error(err))
+	} else {
+		logger = logger.With(zap.Any("params", params))
+	}
 	logger.Info("received request")
 
-	if old := m.lastReqTime.Load(); old < req.Raw.BlockNumber {
+	if old := m.lastReqTime.Load(); old < req.Raw.BlockNumber && !req.Raw.Removed {
 		m.lastReqTime.CAS(old, req.Raw.BlockNumber)
 	}
 
-	if agg, contains := m.aggregators[req.Requester]; contains {
-		agg.handleRequest(req)
+	// The timestamp is persisted with the request so recording its fulfillment doesn't need an RPC call
+	var requested uint64
+	if !req.Raw.Removed {
+		var err error
+		if requested, err = m.blockTimes.Time(req.Raw.BlockHash); err != nil {
+			logger.Warn("failed to fetch request block timestamp", zap.Error(err))
+		}
+	}
+
+	m.lock.Lock()
+	agg, contains := m.aggregators[req.Requester]
+	if contains {
+		agg.handleRequest(req, requested)
+	}
+	m.lock.Unlock()
+	if contains || req.Raw.Removed {
 		return nil
 	}
 
 	logger.Debug("requester unknown; creating a new aggregator monitor")
 
+	// The requester is classified without the lock held since the check can be retried for a while
+	aggregator, err := m.requesterAggregator(req.Requester)
+	if err != nil {
+		return err
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	agg, err := abi.NewAggregator(req.Requester, m.client)
+
+	am, contains := m.aggregators[req.Requester]
+	if !contains {
+		am = m.newRequesterMonitor(req.Requester, aggregator)
+		if am.aggregator == nil {
+			logger.Info("requester is not an aggregator; tracking fulfillments by transaction")
+		}
+		m.aggregators[req.Requester] = am
+	}
+	am.handleRequest(req, requested)
+
+	return nil
+}
+
+// requesterAggregator returns the aggregator binding of a requester, or nil if the requester isn't an aggregator.
+// Aggregators are told apart by their owner. If the node can't be asked, the check is retried since a
+// misclassified aggregator would be tracked by transaction for good.
+func (m *Monitor) requesterAggregator(requester common.Address) (*abi.Aggregator, error) {
+	agg, err := abi.NewAggregator(requester, m.client)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	delay := requesterCheckDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		_, err = agg.Owner(&bind.CallOpts{Context: ctx})
+		cancel()
+
+		if err == nil {
+			return agg, nil
+		}
+		var rpcErr rpc.Error
+		if err == bind.ErrNoCode || errors.As(err, &rpcErr) {
+			// The requester has no code or the node rejected the call, e.g. because it reverted
+			return nil, nil
+		}
+		if attempt == requesterCheckAttempts {
+			zap.L().Warn("failed to check whether requester is an aggregator, treating it as consumer",
+				zap.Error(err), zap.String("requester", requester.String()))
+			return nil, nil
+		}
+
+		zap.L().Warn("failed to check whether requester is an aggregator, retrying", zap.Error(err),
+			zap.String("requester", requester.String()), zap.Int("attempt", attempt))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// newRequesterMonitor creates the monitor for a requester. agg is nil if the requester isn't an aggregator.
+// Fulfillments to aggregators are received from the LogDispatcher via their ChainlinkFulfilled events. Fulfillments
+// to other requesters are detected by the FulfillmentScanner. Must be called with the lock held.
+func (m *Monitor) newRequesterMonitor(requester common.Address, agg *abi.Aggregator) *AggregatorMonitor {
+	if agg == nil {
+		return NewAggregatorMonitor(nil, requester, m)
+	}
+
+	am := NewAggregatorMonitor(agg, requester, m)
+	m.dispatcher.Register(requester, chainlinkFulfilledTopic, am.handleFulfillmentLog)
+	m.dispatcher.Register(requester, answerUpdatedTopic, am.handleAnswerUpdatedLog)
+	m.dispatcher.Register(requester, newRoundTopic, am.handleNewRoundLog)
+	m.dispatcher.Register(requester, responseReceivedTopic, am.handleResponseReceivedLog)
+	go am.loadRound()
+	go am.refreshConfig()
+
+	return am
+}
+
+// scanRoutine scans the new blocks for fulfillment transactions of requesters that aren't aggregators. It runs
+// apart from the head routine since the scanner can lag behind the head, e.g. after a backfill.
+func (m *Monitor) scanRoutine() {
+	zap.L().Info("Starting scan routine")
+	for range m.scanner.notify {
+		if m.backfilling.Load() {
+			continue
+		}
+		m.scanFulfillments(m.scanner.head.Load())
+	}
+}
+
+// scanFulfillments scans the next batch of blocks up to head and lets the monitors of requesters that aren't
+// aggregators handle the scanned blocks.
+func (m *Monitor) scanFulfillments(head uint64) {
+	var consumers []*AggregatorMonitor
+	for _, monitor := range m.aggregatorMonitors() {
+		if monitor.aggregator == nil {
+			consumers = append(consumers, monitor)
+		}
+	}
+	if len(consumers) == 0 {
+		m.scanner.Seek(head)
+		return
 	}
 
-	_, err = agg.Owner(nil)
+	err := m.scanner.Scan(head, func(res *abi.AggregatorChainlinkFulfilled) {
+		for _, consumer := range consumers {
+			consumer.handleFulfillment(res)
+		}
+	}, func(height uint64, timestamp uint64) {
+		for _, consumer := range consumers {
+			consumer.HandleNewBlock(height, timestamp)
+		}
+	})
 	if err != nil {
-		logger.Warn("requester is not an aggregator")
+		zap.L().Error("failed to scan blocks for fulfillments", zap.Error(err))
+	}
+}
+
+// persistedCounters returns the counters that are saved in the store by name.
+func (m *Monitor) persistedCounters() map[string]*prometheus.CounterVec {
+	return map[string]*prometheus.CounterVec{
+		"fulfilled":      m.fulfillmentCounter,
+		"missed":         m.missCounter,
+		"cancelled":      m.cancelCounter,
+		"revenue":        m.revenueCounter,
+		"gas_spent":      m.gasSpentCounter,
+		"link_in":        m.linkInCounter,
+		"link_out":       m.linkOutCounter,
+		"profit_skipped": m.profitSkippedCounter,
+	}
+}
+
+// persistedGauges returns the gauges that accumulate like counters but can decrease. They are saved in the store
+// alongside the counters.
+func (m *Monitor) persistedGauges() map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"profit_link": m.profitLinkGauge,
+		"profit_eth":  m.profitEthGauge,
+	}
+}
+
+// Close closes the store. The state isn't persisted anymore afterwards.
+func (m *Monitor) Close() error {
+	if m.store == nil {
 		return nil
 	}
 
-	am := NewAggregatorMonitor(agg, req.Requester, m)
-	am.handleRequest(req)
+	return m.store.Close()
+}
+
+// restore loads the aggregator monitors and counters from the store and returns the height of the last checkpoint.
+func (m *Monitor) restore() (uint64, bool, error) {
+	height, ok, err := m.store.Height()
+	if err != nil || !ok {
+		return 0, false, err
+	}
 
-	go am.Monitor()
+	counters, err := m.store.Counters()
+	if err != nil {
+		return 0, false, err
+	}
+	for name, vec := range m.persistedCounters() {
+		samples := m.adaptSamples(name, counters[name])
+		if err := restoreCounters(vec, samples); err != nil {
+			return 0, false, fmt.Errorf("failed to restore counter %s: %w", name, err)
+		}
+	}
+	for name, vec := range m.persistedGauges() {
+		if err := restoreGauges(vec, counters[name]); err != nil {
+			return 0, false, fmt.Errorf("failed to restore gauge %s: %w", name, err)
+		}
+	}
+	transfersConfirmed, err := m.store.TransfersConfirmed()
+	if err != nil {
+		return 0, false, err
+	}
+	m.transferLock.Lock()
+	m.transfersConfirmed = transfersConfirmed
+	m.transferLock.Unlock()
 
-	m.aggregators[req.Requester] = am
+	pending, err := m.store.Pending()
+	if err != nil {
+		return 0, false, err
+	}
+	seen, err := m.store.Seen()
+	if err != nil {
+		return 0, false, err
+	}
 
-	return nil
+	aggregators := map[common.Address]*abi.Aggregator{}
+	for addr := range pending {
+		if aggregators[addr], err = m.requesterAggregator(addr); err != nil {
+			return 0, false, err
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for addr, reqs := range pending {
+		am := m.newRequesterMonitor(addr, aggregators[addr])
+		am.restore(reqs, seen[addr])
+
+		m.aggregators[addr] = am
+	}
+
+	zap.L().Info("restored state", zap.Uint64("height", height), zap.Int("aggregators", len(pending)))
+
+	return height, true, nil
+}
+
+// checkpoint persists the current state as processed up to height.
+func (m *Monitor) checkpoint(height uint64) error {
+	cp := &Checkpoint{
+		Height:   height,
+		Counters: map[string][]CounterSample{},
+		Pending:  map[common.Address][]*pendingRequest{},
+		Seen:     map[common.Address]map[string]uint64{},
+		Removed:  map[common.Address][]string{},
+	}
+
+	for _, monitor := range m.aggregatorMonitors() {
+		cp.Pending[monitor.address], cp.Seen[monitor.address], cp.Removed[monitor.address] = monitor.checkpoint()
+	}
+	m.transferLock.Lock()
+	cp.TransfersConfirmed = m.transfersConfirmed
+	for name, vec := range m.persistedCounters() {
+		cp.Counters[name] = snapshotMetrics(vec, m.constLabels)
+	}
+	m.transferLock.Unlock()
+	for name, vec := range m.persistedGauges() {
+		cp.Counters[name] = snapshotMetrics(vec, m.constLabels)
+	}
+
+	return m.store.WriteCheckpoint(cp)
+}
+
+// adaptSamples adapts persisted samples to the labels of the current configuration.
+func (m *Monitor) adaptSamples(name string, samples []CounterSample) []CounterSample {
+	switch name {
+	case "fulfilled", "missed":
+		return m.withParamLabels(samples)
+	}
+
+	return samples
+}
+
+// aggregatorMonitors returns a snapshot of all known aggregator monitors.
+func (m *Monitor) aggregatorMonitors() []*AggregatorMonitor {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	monitors := make([]*AggregatorMonitor, 0, len(m.aggregators))
+	for _, monitor := range m.aggregators {
+		monitors = append(monitors, monitor)
+	}
+	return monitors
 }
 
-func (m *Monitor) HandleFulfillment(res *abi.AggregatorChainlinkFulfilled, req *abi.OracleOracleRequest) {
+// HandleFulfillment records a confirmed fulfillment. responded is the timestamp of the fulfillment block.
+func (m *Monitor) HandleFulfillment(res *abi.AggregatorChainlinkFulfilled, req *pendingRequest, responded uint64) {
 	if old := m.lastResTime.Load(); old < res.Raw.BlockNumber {
 		m.lastResTime.CAS(old, res.Raw.BlockNumber)
 	}
@@ -338,17 +1188,36 @@ func (m *Monitor) HandleFulfillment(res *abi.AggregatorChainlinkFulfilled, req *
 	deltaBlocks := res.Raw.BlockNumber - req.Raw.BlockNumber
 
 	sanitizedSpecID := sanitizeSpecID(req.SpecId)
-	m.responseTimeHistogram.WithLabelValues(sanitizedSpecID).Observe(float64(deltaBlocks))
+	jobName := req.JobName
+	paramValues := m.paramLabelValues(req.OracleOracleRequest)
+	m.responseTimeHistogram.WithLabelValues(append([]string{sanitizedSpecID, jobName}, paramValues...)...).Observe(float64(deltaBlocks))
+	if seconds, ok := m.responseSeconds(req, res, responded); !ok {
+		zap.L().Warn("block timestamps unknown, skipping response time in seconds", zap.String("tx", res.Raw.TxHash.String()),
+			zap.String("spec_id", sanitizedSpecID))
+	} else {
+		m.responseTimeSecondsHistogram.WithLabelValues(sanitizedSpecID, append([]string{jobName}, paramValues...)...).Observe(float64(seconds))
+	}
+
+	m.fulfillmentCounter.WithLabelValues(append([]string{sanitizedSpecID, jobName, req.Requester.String()}, paramValues...)...).Inc()
+	m.revenueCounter.WithLabelValues(sanitizedSpecID, jobName, req.Requester.String(), "fulfilled").Add(float64(req.Payment.Uint64()) / params.Ether)
+
+	m.queueCost(costJob{res: res, req: req.OracleOracleRequest})
+}
+
+func (m *Monitor) HandleCancellation(req *pendingRequest) {
+	sanitizedSpecID := sanitizeSpecID(req.SpecId)
 
-	m.fulfillmentCounter.WithLabelValues(sanitizedSpecID, req.Requester.String()).Inc()
-	m.revenueCounter.WithLabelValues(sanitizedSpecID, req.Requester.String(), "fulfilled").Add(float64(req.Payment.Uint64()) / params.Ether)
+	m.cancelCounter.WithLabelValues(sanitizedSpecID, req.Requester.String()).Inc()
+	m.revenueCounter.WithLabelValues(sanitizedSpecID, req.JobName, req.Requester.String(), "cancelled").Add(float64(req.Payment.Uint64()) / params.Ether)
 }
 
-func (m *Monitor) HandleMiss(req *abi.OracleOracleRequest) {
+func (m *Monitor) HandleMiss(req *pendingRequest) {
 	sanitizedSpecID := sanitizeSpecID(req.SpecId)
+	jobName := req.JobName
+	paramValues := m.paramLabelValues(req.OracleOracleRequest)
 
-	m.missCounter.WithLabelValues(sanitizedSpecID, req.Requester.String()).Inc()
-	m.revenueCounter.WithLabelValues(sanitizedSpecID, req.Requester.String(), "missed").Add(float64(req.Payment.Uint64()) / params.Ether)
+	m.missCounter.WithLabelValues(append([]string{sanitizedSpecID, jobName, req.Requester.String()}, paramValues...)...).Inc()
+	m.revenueCounter.WithLabelValues(sanitizedSpecID, jobName, req.Requester.String(), "missed").Add(float64(req.Payment.Uint64()) / params.Ether)
 }
 
 func sanitizeSpecID(specID [32]byte) string {
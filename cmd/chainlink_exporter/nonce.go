@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+type (
+	// NonceTracker follows the confirmed and pending nonces of a node address to detect stuck transactions. It only
+	// sees nonces, so a transaction replaced by another one with the same nonce isn't detected. That would need the
+	// pending transaction of every nonce, which most endpoints don't expose.
+	NonceTracker struct {
+		confirmed uint64
+		pending   uint64
+		// firstSeen is the time each pending nonce was first seen
+		firstSeen map[uint64]time.Time
+
+		initialized bool
+		lock        sync.Mutex
+	}
+)
+
+func NewNonceTracker() *NonceTracker {
+	return &NonceTracker{
+		firstSeen: map[uint64]time.Time{},
+	}
+}
+
+// Update records the current nonces. It returns the number of pending transactions, the age of the oldest one and
+// the number of pending transactions that disappeared without being mined since the last update.
+func (t *NonceTracker) Update(confirmed, pending uint64, now time.Time) (gap uint64, age time.Duration, dropped uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if pending < confirmed {
+		// The endpoint's pool doesn't know the latest block yet
+		pending = confirmed
+	}
+	if t.initialized && pending < t.pending && confirmed < t.pending {
+		// Nonces that were pending are neither pending nor mined anymore
+		dropped = t.pending - pending
+	}
+
+	for nonce := range t.firstSeen {
+		if nonce < confirmed || nonce >= pending {
+			delete(t.firstSeen, nonce)
+		}
+	}
+	for nonce := confirmed; nonce < pending; nonce++ {
+		if _, ok := t.firstSeen[nonce]; !ok {
+			t.firstSeen[nonce] = now
+		}
+	}
+	t.confirmed, t.pending, t.initialized = confirmed, pending, true
+
+	if pending == confirmed {
+		return 0, 0, dropped
+	}
+
+	return pending - confirmed, now.Sub(t.firstSeen[confirmed]), dropped
+}
+
+// updateNonces exports the pending transactions of all node addresses. Updates that overlap with a running one are
+// skipped since nonces applied out of order would look like dropped transactions.
+func (m *Monitor) updateNonces() {
+	if !m.updatingNonces.CAS(false, true) {
+		return
+	}
+	defer m.updatingNonces.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	for _, node := range m.fulfillmentAddrs {
+		confirmed, err := m.client.NonceAt(ctx, node, nil)
+		if err != nil {
+			zap.L().Error("failed to fetch nonce", zap.Error(err), zap.String("node", node.String()))
+			continue
+		}
+		pending, err := m.client.PendingNonceAt(ctx, node)
+		if err != nil {
+			zap.L().Error("failed to fetch pending nonce", zap.Error(err), zap.String("node", node.String()))
+			continue
+		}
+
+		gap, age, dropped := m.nonces[node].Update(confirmed, pending, time.Now())
+		m.nonceGapGauge.WithLabelValues(node.String()).Set(float64(gap))
+		m.pendingAgeGauge.WithLabelValues(node.String()).Set(age.Seconds())
+		if dropped > 0 {
+			zap.L().Warn("pending transactions dropped", zap.String("node", node.String()),
+				zap.Uint64("dropped", dropped), zap.Uint64("nonce", confirmed))
+			m.droppedTxCounter.WithLabelValues(node.String()).Add(float64(dropped))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNonceTrackerUpdate(t *testing.T) {
+	type update struct {
+		confirmed, pending uint64
+		at                 time.Duration
+	}
+	tests := []struct {
+		name    string
+		updates []update
+		want    string
+	}{
+		{name: "nothing pending", updates: []update{{5, 5, 0}}, want: "0 0s 0"},
+		{name: "pending", updates: []update{{5, 7, 0}}, want: "2 0s 0"},
+		{name: "pool behind chain", updates: []update{{5, 4, 0}}, want: "0 0s 0"},
+		{name: "still pending", updates: []update{{5, 7, 0}, {5, 8, time.Minute}}, want: "3 1m0s 0"},
+		{name: "partially mined", updates: []update{{5, 7, 0}, {6, 7, time.Minute}}, want: "1 1m0s 0"},
+		{name: "all mined", updates: []update{{5, 7, 0}, {7, 7, time.Minute}}, want: "0 0s 0"},
+		{name: "mined past pending", updates: []update{{5, 8, 0}, {9, 9, time.Minute}}, want: "0 0s 0"},
+		{name: "dropped", updates: []update{{5, 8, 0}, {5, 6, time.Minute}}, want: "1 1m0s 2"},
+		{name: "mined and dropped", updates: []update{{5, 8, 0}, {6, 6, time.Minute}}, want: "0 0s 2"},
+		{name: "replaced nonce", updates: []update{{5, 8, 0}, {6, 9, time.Minute}, {7, 8, 2 * time.Minute}},
+			want: "1 2m0s 1"},
+	}
+
+	start := time.Unix(1600000000, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewNonceTracker()
+			var gap, dropped uint64
+			var age time.Duration
+			for _, u := range tt.updates {
+				gap, age, dropped = tracker.Update(u.confirmed, u.pending, start.Add(u.at))
+			}
+			if got := fmt.Sprint(gap, age, dropped); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
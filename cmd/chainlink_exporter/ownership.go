@@ -0,0 +1,124 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+	"time"
+)
+
+var (
+	ownershipTransferredTopic = mustEventID(abi.OracleABI, "OwnershipTransferred")
+	ownershipRenouncedTopic   = mustEventID(abi.OracleABI, "OwnershipRenounced")
+)
+
+// handleOwnershipTransferredLog reports an ownership transfer of the oracle.
+func (m *Monitor) handleOwnershipTransferredLog(l types.Log) error {
+	event, err := m.oracle.ParseOwnershipTransferred(l)
+	if err != nil {
+		return err
+	}
+	if !m.newOwnershipLog(l) {
+		return nil
+	}
+
+	zap.L().Error("oracle ownership transferred", zap.Uint64("height", l.BlockNumber),
+		zap.String("previous_owner", event.PreviousOwner.String()), zap.String("new_owner", event.NewOwner.String()),
+		zap.String("tx", l.TxHash.String()))
+	go m.updatePermissions()
+
+	return nil
+}
+
+// handleOwnershipRenouncedLog reports that the owner of the oracle renounced the ownership.
+func (m *Monitor) handleOwnershipRenouncedLog(l types.Log) error {
+	event, err := m.oracle.ParseOwnershipRenounced(l)
+	if err != nil {
+		return err
+	}
+	if !m.newOwnershipLog(l) {
+		return nil
+	}
+
+	zap.L().Error("oracle ownership renounced", zap.Uint64("height", l.BlockNumber),
+		zap.String("previous_owner", event.PreviousOwner.String()), zap.String("tx", l.TxHash.String()))
+	go m.updatePermissions()
+
+	return nil
+}
+
+// newOwnershipLog reports whether an ownership log is a new change. Logs replayed by the backfill, logs that were
+// already delivered and removed logs aren't.
+func (m *Monitor) newOwnershipLog(l types.Log) bool {
+	key := logKey{tx: l.TxHash, index: l.Index}
+
+	m.ownershipLock.Lock()
+	defer m.ownershipLock.Unlock()
+
+	if l.Removed {
+		delete(m.ownershipLogs, key)
+		return false
+	}
+	if m.ownershipLogs[key] {
+		return false
+	}
+	m.ownershipLogs[key] = true
+
+	if l.BlockNumber <= m.historicalHeight.Load() {
+		zap.L().Debug("skipping historical ownership log", zap.Uint64("height", l.BlockNumber),
+			zap.String("tx", l.TxHash.String()))
+		return false
+	}
+
+	return true
+}
+
+// updatePermissions exports the owner of the oracle and whether the node addresses may fulfill requests. Changes
+// since the last update are reported. Updates that overlap with a running one are skipped.
+func (m *Monitor) updatePermissions() {
+	if !m.updatingPermissions.CAS(false, true) {
+		return
+	}
+	defer m.updatingPermissions.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	owner, err := m.oracle.Owner(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		zap.L().Error("failed to fetch oracle owner", zap.Error(err))
+		return
+	}
+	if m.owner != nil && *m.owner != owner {
+		zap.L().Error("oracle owner changed", zap.String("previous_owner", m.owner.String()),
+			zap.String("new_owner", owner.String()))
+		m.permissionChangeCounter.WithLabelValues("owner").Inc()
+		m.ownerInfoGauge.DeleteLabelValues(m.owner.String())
+	}
+	m.owner = &owner
+	m.ownerInfoGauge.WithLabelValues(owner.String()).Set(1)
+
+	for _, node := range m.fulfillmentAddrs {
+		authorized, err := m.oracle.GetAuthorizationStatus(&bind.CallOpts{Context: ctx}, node)
+		if err != nil {
+			zap.L().Error("failed to fetch node authorization", zap.Error(err), zap.String("node", node.String()))
+			continue
+		}
+		if previous, ok := m.authorized[node]; ok && previous != authorized {
+			zap.L().Error("node authorization changed", zap.String("node", node.String()),
+				zap.Bool("authorized", authorized))
+			m.permissionChangeCounter.WithLabelValues("authorization").Inc()
+		} else if !ok && !authorized {
+			zap.L().Error("node is not authorized to fulfill requests", zap.String("node", node.String()))
+		}
+		m.authorized[node] = authorized
+
+		value := 0.0
+		if authorized {
+			value = 1
+		}
+		m.nodeAuthorizedGauge.WithLabelValues(node.String()).Set(value)
+	}
+}
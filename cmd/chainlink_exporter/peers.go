@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"math/big"
+)
+
+type (
+	// responder is an oracle that responded to a round
+	responder struct {
+		sender common.Address
+		// tx and index identify the ResponseReceived log
+		tx    common.Hash
+		index uint
+	}
+)
+
+// recordResponder records a response to a round and returns its rank among the responses of the round. Oracles that
+// are part of the aggregator with multiple jobs respond more than once. Must be called with the lock held.
+func (a *AggregatorMonitor) recordResponder(round uint64, r responder) int {
+	for n := range a.responders {
+		if n+maxResponseRounds < round {
+			delete(a.responders, n)
+		}
+	}
+
+	for i, known := range a.responders[round] {
+		if known.tx == r.tx && known.index == r.index {
+			// Delivered twice
+			return i + 1
+		}
+	}
+	a.responders[round] = append(a.responders[round], r)
+
+	return len(a.responders[round])
+}
+
+// rankResponse records the rank of our oracle's response to a round. Must be called with the lock held.
+func (a *AggregatorMonitor) rankResponse(round *big.Int, rank int) {
+	if a.rankedRound != nil && round.Cmp(a.rankedRound) <= 0 {
+		// Already ranked, e.g. replayed by the backfill
+		return
+	}
+	a.rankedRound = round
+
+	a.monitor.rankHistogram.WithLabelValues(a.address.String()).Observe(float64(rank))
+
+	logger := zap.L().With(zap.String("address", a.address.String()), zap.Uint64("round", round.Uint64()),
+		zap.Int("rank", rank))
+	if a.config == nil {
+		logger.Debug("response ranked; minimum responses unknown")
+		return
+	}
+
+	late := uint64(rank) > a.config.minimumResponses
+	if late {
+		a.monitor.lateCounter.WithLabelValues(a.address.String()).Inc()
+	}
+
+	ahead := make([]string, 0, rank-1)
+	for _, r := range a.responders[round.Uint64()][:rank-1] {
+		ahead = append(ahead, r.sender.String())
+	}
+	logger.Info("response ranked", zap.Bool("late", late), zap.Uint64("minimum_responses", a.config.minimumResponses),
+		zap.Int("oracles", len(a.config.oracles)), zap.Strings("ahead", ahead))
+}
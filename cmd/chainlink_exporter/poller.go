@@ -0,0 +1,169 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+	"math/big"
+	"time"
+)
+
+const (
+	// pollMaxBlocks is the maximum number of blocks processed per poll. Larger gaps are caught up over several polls.
+	pollMaxBlocks = 100
+)
+
+// pollRoutine is the ingestion routine for RPC endpoints without subscription support. It fetches new headers with
+// eth_getBlockByNumber and the events of the new blocks with FilterLogs every cfg.PollInterval.
+func (m *Monitor) pollRoutine() {
+	// cursor is the last processed block
+	var cursor uint64
+
+	for {
+		zap.L().Info("Starting poll routine", zap.Duration("interval", m.cfg.PollInterval))
+		func() {
+			ticker := time.NewTicker(m.cfg.PollInterval)
+			defer ticker.Stop()
+
+			for {
+				var err error
+				cursor, err = m.poll(cursor)
+				if err != nil {
+					zap.L().Error("failed to poll", zap.Error(err), zap.Uint64("height", cursor))
+					return
+				}
+				<-ticker.C
+			}
+		}()
+
+		zap.L().Warn("poll routine died. restarting in 5sec")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// poll processes the blocks after cursor up to the current head and returns the new cursor. If the chain was
+// reorganized, the cursor is moved back to the common ancestor so the orphaned blocks are polled again.
+func (m *Monitor) poll(cursor uint64) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	head, err := headerByNumber(ctx, m.client, nil)
+	cancel()
+	if err != nil {
+		return cursor, err
+	}
+
+	if cursor == 0 && head.Number > 0 {
+		// Older blocks are covered by the backfill
+		cursor = uint64(head.Number) - 1
+	}
+	to := uint64(head.Number)
+	if to > cursor+pollMaxBlocks {
+		to = cursor + pollMaxBlocks
+	}
+	if to <= cursor {
+		return cursor, nil
+	}
+
+	// Headers are fed one by one so the chain tracker can detect reorgs
+	var last *rpcHeader
+	for n := cursor + 1; n <= to; n++ {
+		header := head
+		if n != uint64(head.Number) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+			header, err = headerByNumber(ctx, m.client, new(big.Int).SetUint64(n))
+			cancel()
+			if err != nil {
+				return cursor, err
+			}
+		}
+
+		if ancestor, reorged := m.checkReorg(header); reorged && ancestor < cursor {
+			cursor = ancestor
+		}
+		// Requests and fulfillments of the polled blocks find their timestamps in the cache
+		m.blockTimes.Add(header.Hash, uint64(header.Time))
+		last = header
+	}
+
+	if err := m.pollLogs(cursor+1, to); err != nil {
+		return cursor, err
+	}
+	m.processHead(last)
+
+	return to, nil
+}
+
+// pollLogs fetches the requests, cancellations and dispatched events in [from, to] and passes them to the
+// same handlers as the subscriptions. Requests are handled first so the events of new aggregators are polled as well.
+func (m *Monitor) pollLogs(from, to uint64) error {
+	logs, err := m.filterLogs(from, to, ethereum.FilterQuery{
+		Addresses: []common.Address{m.addr},
+		Topics:    [][]common.Hash{{oracleRequestTopic, cancelOracleRequestTopic}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var cancellations []*abi.OracleCancelOracleRequest
+	for _, l := range logs {
+		switch l.Topics[0] {
+		case oracleRequestTopic:
+			req, err := m.oracle.ParseOracleRequest(l)
+			if err != nil {
+				zap.L().Warn("failed to parse polled request", zap.Error(err), zap.String("tx", l.TxHash.String()))
+				continue
+			}
+			if err := m.handleRequest(req); err != nil {
+				zap.L().Warn("failed to handle request", zap.Error(err))
+			}
+		case cancelOracleRequestTopic:
+			c, err := m.oracle.ParseCancelOracleRequest(l)
+			if err != nil {
+				zap.L().Warn("failed to parse polled cancellation", zap.Error(err), zap.String("tx", l.TxHash.String()))
+				continue
+			}
+			cancellations = append(cancellations, c)
+		}
+	}
+
+	for _, query := range m.dispatcher.Queries() {
+		logs, err = m.filterLogs(from, to, query)
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			m.dispatcher.Dispatch(l)
+		}
+	}
+
+	for _, c := range cancellations {
+		m.handleCancellation(c)
+	}
+
+	return nil
+}
+
+// filterLogs returns the logs matching query in [from, to]. It fails if a log belongs to a block that isn't part of
+// the tracked chain anymore.
+func (m *Monitor) filterLogs(from, to uint64, query ethereum.FilterQuery) ([]types.Log, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	query.FromBlock = new(big.Int).SetUint64(from)
+	query.ToBlock = new(big.Int).SetUint64(to)
+	logs, err := m.client.FilterLogs(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range logs {
+		if hash, ok := m.chain.Hash(l.BlockNumber); ok && hash != l.BlockHash {
+			return nil, fmt.Errorf("block %d changed while polling", l.BlockNumber)
+		}
+	}
+
+	return logs, nil
+}
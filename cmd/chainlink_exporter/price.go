@@ -0,0 +1,78 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPriceFeedDecimals is the number of decimals of the LINK/ETH price feed answer
+	DefaultPriceFeedDecimals = 18
+
+	// priceCacheDuration is the time a price read from a feed is reused for
+	priceCacheDuration = time.Minute
+)
+
+type (
+	// PriceSource provides the price of one LINK in ETH.
+	PriceSource interface {
+		LinkEthPrice() (float64, error)
+	}
+
+	// StaticPrice is a fixed LINK/ETH price.
+	StaticPrice float64
+
+	// FeedPrice reads the LINK/ETH price from the latest answer of a price feed aggregator.
+	FeedPrice struct {
+		feed     *abi.Aggregator
+		decimals uint8
+
+		price   float64
+		updated time.Time
+		lock    sync.Mutex
+	}
+)
+
+func (p StaticPrice) LinkEthPrice() (float64, error) {
+	return float64(p), nil
+}
+
+func NewFeedPrice(client EthClient, address common.Address, decimals uint8) (*FeedPrice, error) {
+	feed, err := abi.NewAggregator(address, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FeedPrice{feed: feed, decimals: decimals}, nil
+}
+
+func (p *FeedPrice) LinkEthPrice() (float64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if time.Since(p.updated) < priceCacheDuration {
+		return p.price, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	answer, err := p.feed.LatestAnswer(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read price feed: %w", err)
+	}
+	if answer.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid price feed answer %s", answer)
+	}
+
+	price, _ := new(big.Float).Quo(new(big.Float).SetInt(answer), big.NewFloat(math.Pow10(int(p.decimals)))).Float64()
+	p.price, p.updated = price, time.Now()
+
+	return price, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"time"
+)
+
+const (
+	// trackedBlocks is the number of recent block hashes kept to detect reorgs
+	trackedBlocks = 128
+)
+
+type (
+	// ChainTracker keeps the hashes of recent blocks to detect chain reorganizations.
+	ChainTracker struct {
+		client EthClient
+		hashes map[uint64]common.Hash
+		head   uint64
+	}
+
+	// rpcHeader is a block header as returned by the node. types.Header can't hash headers with fields added after
+	// it, e.g. the base fee, so blocks are identified by the hash the node reports.
+	rpcHeader struct {
+		Number     hexutil.Uint64 `json:"number"`
+		Hash       common.Hash    `json:"hash"`
+		ParentHash common.Hash    `json:"parentHash"`
+		Time       hexutil.Uint64 `json:"timestamp"`
+	}
+)
+
+func NewChainTracker(client EthClient) *ChainTracker {
+	return &ChainTracker{
+		client: client,
+		hashes: map[uint64]common.Hash{},
+	}
+}
+
+// HandleHeader records a new head. If the head doesn't extend the tracked chain, the common ancestor with the
+// tracked chain is searched and returned together with the number of orphaned blocks.
+func (c *ChainTracker) HandleHeader(header *rpcHeader) (ancestor uint64, depth uint64, err error) {
+	number := uint64(header.Number)
+	defer c.prune()
+
+	if hash, ok := c.hashes[number]; ok && hash == header.Hash {
+		// Already known
+		return 0, 0, nil
+	}
+
+	parentHash, known := c.hashes[number-1]
+	if c.head == 0 || !known || (parentHash == header.ParentHash && number > c.head) {
+		c.record(number, header.Hash)
+		return 0, 0, nil
+	}
+
+	// Walk back the new chain until it joins the tracked chain
+	oldHead := c.head
+	current := header
+	for {
+		c.hashes[uint64(current.Number)] = current.Hash
+
+		parent := uint64(current.Number) - 1
+		if hash, ok := c.hashes[parent]; !ok || hash == current.ParentHash {
+			ancestor = parent
+			break
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		current, err = headerByHash(ctx, c.client, current.ParentHash)
+		cancel()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for n := number + 1; n <= oldHead; n++ {
+		delete(c.hashes, n)
+	}
+	c.head = number
+
+	return ancestor, oldHead - ancestor, nil
+}
+
+// Hash returns the tracked hash of the given block.
+func (c *ChainTracker) Hash(number uint64) (common.Hash, bool) {
+	hash, ok := c.hashes[number]
+	return hash, ok
+}
+
+func (c *ChainTracker) record(number uint64, hash common.Hash) {
+	c.hashes[number] = hash
+	c.head = number
+}
+
+func (c *ChainTracker) prune() {
+	for n := range c.hashes {
+		if n+trackedBlocks <= c.head {
+			delete(c.hashes, n)
+		}
+	}
+}
+
+// headerByNumber returns the header of the given block, or the latest header if number is nil.
+func headerByNumber(ctx context.Context, client EthClient, number *big.Int) (*rpcHeader, error) {
+	block := "latest"
+	if number != nil {
+		block = hexutil.EncodeBig(number)
+	}
+
+	var header *rpcHeader
+	if err := client.CallContext(ctx, &header, "eth_getBlockByNumber", block, false); err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, ethereum.NotFound
+	}
+
+	return header, nil
+}
+
+// headerByHash returns the header of the block with the given hash.
+func headerByHash(ctx context.Context, client EthClient, hash common.Hash) (*rpcHeader, error) {
+	var header *rpcHeader
+	if err := client.CallContext(ctx, &header, "eth_getBlockByHash", hash, false); err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, ethereum.NotFound
+	}
+
+	return header, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+	"testing"
+)
+
+// headerClient serves eth_getBlockByHash from a set of headers encoded the way a node returns them.
+type headerClient struct {
+	EthClient
+	headers map[common.Hash]json.RawMessage
+}
+
+func (c *headerClient) CallContext(_ context.Context, result interface{}, method string, args ...interface{}) error {
+	if method != "eth_getBlockByHash" {
+		return fmt.Errorf("unexpected method %s", method)
+	}
+	header, ok := c.headers[args[0].(common.Hash)]
+	if !ok {
+		return ethereum.NotFound
+	}
+	return json.Unmarshal(header, result)
+}
+
+// londonHeader returns a post-London header and its JSON encoding. The hash covers the base fee, which
+// types.Header doesn't know about.
+func londonHeader(t *testing.T, number uint64, parent common.Hash, extra string) (*rpcHeader, json.RawMessage) {
+	baseFee := big.NewInt(7)
+	fields := []interface{}{
+		parent, types.EmptyUncleHash, common.Address{}, common.Hash{}, types.EmptyRootHash, types.EmptyRootHash,
+		types.Bloom{}, big.NewInt(0), new(big.Int).SetUint64(number), uint64(30000000), uint64(0), 1600000000 + number*12,
+		[]byte(extra), common.Hash{}, types.BlockNonce{}, baseFee,
+	}
+	encoded, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := crypto.Keccak256Hash(encoded)
+
+	data, err := json.Marshal(map[string]interface{}{
+		"number":           hexutil.Uint64(number),
+		"hash":             hash,
+		"parentHash":       parent,
+		"sha3Uncles":       types.EmptyUncleHash,
+		"miner":            common.Address{},
+		"stateRoot":        common.Hash{},
+		"transactionsRoot": types.EmptyRootHash,
+		"receiptsRoot":     types.EmptyRootHash,
+		"logsBloom":        types.Bloom{},
+		"difficulty":       (*hexutil.Big)(big.NewInt(0)),
+		"gasLimit":         hexutil.Uint64(30000000),
+		"gasUsed":          hexutil.Uint64(0),
+		"timestamp":        hexutil.Uint64(1600000000 + number*12),
+		"extraData":        hexutil.Bytes(extra),
+		"mixHash":          common.Hash{},
+		"nonce":            types.BlockNonce{},
+		"baseFeePerGas":    (*hexutil.Big)(baseFee),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header rpcHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		t.Fatal(err)
+	}
+	var legacy types.Header
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Hash() == header.Hash {
+		t.Fatalf("types.Header hashes block %d correctly, the base fee isn't part of the hash", number)
+	}
+
+	return &header, data
+}
+
+func TestChainTrackerHandleHeader(t *testing.T) {
+	client := &headerClient{headers: map[common.Hash]json.RawMessage{}}
+	blocks := map[string]*rpcHeader{}
+	// Chain a is the canonical chain, chain b forks off after block 3
+	var parent common.Hash
+	for n := uint64(1); n <= 5; n++ {
+		header, data := londonHeader(t, n, parent, "a")
+		blocks[fmt.Sprintf("a%d", n)], client.headers[header.Hash], parent = header, data, header.Hash
+	}
+	parent = blocks["a3"].Hash
+	for n := uint64(4); n <= 5; n++ {
+		header, data := londonHeader(t, n, parent, "b")
+		blocks[fmt.Sprintf("b%d", n)], client.headers[header.Hash], parent = header, data, header.Hash
+	}
+	// c5 builds on a block the node doesn't know
+	orphan, _ := londonHeader(t, 4, blocks["a3"].Hash, "c")
+	blocks["c5"], _ = londonHeader(t, 5, orphan.Hash, "c")
+
+	tests := []struct {
+		name    string
+		heads   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "extends chain", heads: []string{"a1", "a2", "a3", "a4", "a5"}, want: "0 0"},
+		{name: "known head", heads: []string{"a1", "a2", "a3", "a3"}, want: "0 0"},
+		{name: "gap", heads: []string{"a1", "a3"}, want: "0 0"},
+		{name: "sibling head", heads: []string{"a1", "a2", "a3", "a4", "b4"}, want: "3 1"},
+		{name: "lower head", heads: []string{"a1", "a2", "a3", "a4", "a5", "b4"}, want: "3 2"},
+		{name: "higher head", heads: []string{"a1", "a2", "a3", "a4", "b5"}, want: "3 1"},
+		{name: "back to old chain", heads: []string{"a1", "a2", "a3", "a4", "b4", "a5"}, want: "3 1"},
+
+		{name: "unknown parent", heads: []string{"a1", "a2", "a3", "a4", "c5"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewChainTracker(client)
+			var ancestor, depth uint64
+			var err error
+			for _, head := range tt.heads {
+				ancestor, depth, err = tracker.HandleHeader(blocks[head])
+				if err != nil {
+					break
+				}
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d %d", ancestor, depth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprint(ancestor, depth); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+
+			last := blocks[tt.heads[len(tt.heads)-1]]
+			if hash, _ := tracker.Hash(uint64(last.Number)); hash != last.Hash {
+				t.Errorf("tracked hash %s, want %s", hash.String(), last.Hash.String())
+			}
+		})
+	}
+}
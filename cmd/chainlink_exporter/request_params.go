@@ -0,0 +1,140 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+)
+
+const (
+	// requestDataVersion is the data version of requests built with Chainlink.sol
+	requestDataVersion = 1
+
+	// paramLabelPrefix is prepended to the keys of request parameters to form their label names
+	paramLabelPrefix = "param_"
+	// hostParam is a pseudo parameter with the host of the requested URL
+	hostParam = "host"
+)
+
+var (
+	// urlParams are the parameters that contain the URL a job requests
+	urlParams = []string{"get", "post", "url"}
+)
+
+// decodeRequestParams decodes the parameters of a request. Chainlink.sol encodes them as the key value pairs of a
+// CBOR map without the map header.
+func decodeRequestParams(version *big.Int, data []byte) (map[string]interface{}, error) {
+	if version == nil || version.Cmp(big.NewInt(requestDataVersion)) != 0 {
+		return nil, fmt.Errorf("unsupported data version %s", version)
+	}
+
+	buf := make([]byte, 0, len(data)+2)
+	buf = append(buf, 0xbf)
+	buf = append(buf, data...)
+	buf = append(buf, 0xff)
+	v, err := decodeCBOR(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request data: %w", err)
+	}
+
+	return v.(map[string]interface{}), nil
+}
+
+// paramLabelNames returns the label names of the allow-listed request parameters.
+func paramLabelNames(keys []string) []string {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = paramLabelPrefix + key
+	}
+
+	return names
+}
+
+// paramLabelValues returns the label values of the allow-listed request parameters of req. Parameters that are
+// missing or can't be decoded have an empty value.
+func (m *Monitor) paramLabelValues(req *abi.OracleOracleRequest) []string {
+	values := make([]string, len(m.cfg.ParamLabels))
+	if len(values) == 0 {
+		return values
+	}
+
+	params, err := decodeRequestParams(req.DataVersion, req.Data)
+	if err != nil {
+		return values
+	}
+	for i, key := range m.cfg.ParamLabels {
+		values[i] = formatParam(params, key)
+	}
+
+	return values
+}
+
+// formatParam formats a request parameter as a label value.
+func formatParam(params map[string]interface{}, key string) string {
+	if key == hostParam {
+		if _, ok := params[hostParam]; !ok {
+			for _, param := range urlParams {
+				if s, ok := params[param].(string); ok {
+					if u, err := url.Parse(s); err == nil {
+						return u.Host
+					}
+				}
+			}
+			return ""
+		}
+	}
+
+	switch v := params[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		// Paths are arrays of keys
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = fmt.Sprint(part)
+		}
+		return strings.Join(parts, ".")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// validParamKey reports whether key can be used in a label name.
+func validParamKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withParamLabels adapts persisted samples to the configured request parameter labels. Labels of parameters that
+// aren't allow-listed anymore are dropped, labels of new ones are empty.
+func (m *Monitor) withParamLabels(samples []CounterSample) []CounterSample {
+	names := paramLabelNames(m.cfg.ParamLabels)
+
+	adapted := make([]CounterSample, len(samples))
+	for i, sample := range samples {
+		labels := map[string]string{}
+		for name, value := range sample.Labels {
+			if !strings.HasPrefix(name, paramLabelPrefix) {
+				labels[name] = value
+			}
+		}
+		for _, name := range names {
+			labels[name] = sample.Labels[name]
+		}
+		adapted[i] = CounterSample{Labels: labels, Value: sample.Value}
+	}
+
+	return adapted
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+const (
+	// blockTimeCacheSize is the number of block timestamps kept in the cache
+	blockTimeCacheSize = 1024
+)
+
+type (
+	// BlockTimeCache caches the timestamps of blocks by hash. The oldest timestamps are evicted first.
+	BlockTimeCache struct {
+		client EthClient
+		times  map[common.Hash]uint64
+		order  []common.Hash
+		lock   sync.Mutex
+	}
+
+	// SpecHistogramVec is a histogram vector whose first label is the spec ID. Specs can have their own buckets,
+	// all other specs share the default buckets.
+	SpecHistogramVec struct {
+		fallback *prometheus.HistogramVec
+		specs    map[string]*prometheus.HistogramVec
+	}
+)
+
+func NewBlockTimeCache(client EthClient) *BlockTimeCache {
+	return &BlockTimeCache{
+		client: client,
+		times:  map[common.Hash]uint64{},
+	}
+}
+
+// Add caches the timestamp of a block.
+func (c *BlockTimeCache) Add(hash common.Hash, timestamp uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.times[hash]; ok {
+		return
+	}
+	c.times[hash] = timestamp
+	c.order = append(c.order, hash)
+	if len(c.order) > blockTimeCacheSize {
+		delete(c.times, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Cached returns the timestamp of a block if it is cached.
+func (c *BlockTimeCache) Cached(hash common.Hash) (uint64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	timestamp, ok := c.times[hash]
+	return timestamp, ok
+}
+
+// Time returns the timestamp of a block. The header is fetched if the block isn't cached.
+func (c *BlockTimeCache) Time(hash common.Hash) (uint64, error) {
+	if timestamp, ok := c.Cached(hash); ok {
+		return timestamp, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	header, err := headerByHash(ctx, c.client, hash)
+	if err != nil {
+		return 0, err
+	}
+	c.Add(hash, uint64(header.Time))
+
+	return uint64(header.Time), nil
+}
+
+// responseSeconds returns the seconds between the blocks of a request and its fulfillment. Timestamps that couldn't
+// be fetched when the request or the fulfillment was seen are looked up in the cache. ok is false if they are still
+// unknown.
+func (m *Monitor) responseSeconds(req *pendingRequest, res *abi.AggregatorChainlinkFulfilled, responded uint64) (seconds uint64, ok bool) {
+	requested := req.Time
+	if requested == 0 {
+		if requested, ok = m.blockTimes.Cached(req.Raw.BlockHash); !ok {
+			return 0, false
+		}
+	}
+	if responded == 0 {
+		if responded, ok = m.blockTimes.Cached(res.Raw.BlockHash); !ok {
+			return 0, false
+		}
+	}
+	if responded < requested {
+		return 0, true
+	}
+
+	return responded - requested, true
+}
+
+// NewSpecHistogramVec creates a histogram vector with the buckets of opts for all specs without buckets in
+// specBuckets. The spec ID label is prepended to labelNames.
+func NewSpecHistogramVec(opts prometheus.HistogramOpts, specBuckets map[string][]float64, labelNames []string) *SpecHistogramVec {
+	labelNames = append([]string{"spec_id"}, labelNames...)
+	v := &SpecHistogramVec{
+		fallback: prometheus.NewHistogramVec(opts, labelNames),
+		specs:    map[string]*prometheus.HistogramVec{},
+	}
+	for specID, buckets := range specBuckets {
+		specOpts := opts
+		specOpts.Buckets = buckets
+		v.specs[specID] = prometheus.NewHistogramVec(specOpts, labelNames)
+	}
+
+	return v
+}
+
+// Describe implements prometheus.Collector. All histograms share the same descriptor.
+func (v *SpecHistogramVec) Describe(ch chan<- *prometheus.Desc) {
+	v.fallback.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (v *SpecHistogramVec) Collect(ch chan<- prometheus.Metric) {
+	v.fallback.Collect(ch)
+	for _, vec := range v.specs {
+		vec.Collect(ch)
+	}
+}
+
+// WithLabelValues returns the histogram of a spec ID and the other label values.
+func (v *SpecHistogramVec) WithLabelValues(specID string, lvs ...string) prometheus.Observer {
+	vec, ok := v.specs[specID]
+	if !ok {
+		vec = v.fallback
+	}
+
+	return vec.WithLabelValues(append([]string{specID}, lvs...)...)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+	"math/big"
+	"time"
+)
+
+var (
+	answerUpdatedTopic = mustEventID(abi.AggregatorABI, "AnswerUpdated")
+	newRoundTopic      = mustEventID(abi.AggregatorABI, "NewRound")
+)
+
+type (
+	// roundState is the latest answer and round of an aggregator
+	roundState struct {
+		answer  *big.Int
+		roundID *big.Int
+		// timestamp is the time the latest answer was updated at
+		timestamp uint64
+		// interval is the number of seconds between the latest answer and the one of the previous round
+		interval uint64
+		// startedRound is the latest round that was started, answered or not
+		startedRound *big.Int
+	}
+)
+
+// loadRound initializes the round state from the latest answer of the aggregator.
+func (a *AggregatorMonitor) loadRound() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	opts := &bind.CallOpts{Context: ctx}
+
+	roundID, err := a.aggregator.LatestRound(opts)
+	if err != nil {
+		zap.L().Warn("failed to fetch latest round", zap.Error(err), zap.String("address", a.address.String()))
+		return
+	}
+	answer, err := a.aggregator.LatestAnswer(opts)
+	if err != nil {
+		zap.L().Warn("failed to fetch latest answer", zap.Error(err), zap.String("address", a.address.String()))
+		return
+	}
+	timestamp, err := a.aggregator.LatestTimestamp(opts)
+	if err != nil {
+		zap.L().Warn("failed to fetch latest timestamp", zap.Error(err), zap.String("address", a.address.String()))
+		return
+	}
+
+	var interval uint64
+	if roundID.Sign() > 0 {
+		previous, err := a.aggregator.GetTimestamp(opts, new(big.Int).Sub(roundID, big.NewInt(1)))
+		if err != nil {
+			zap.L().Warn("failed to fetch previous round timestamp", zap.Error(err), zap.String("address", a.address.String()))
+		} else if previous.Sign() > 0 && previous.Cmp(timestamp) < 0 {
+			interval = timestamp.Uint64() - previous.Uint64()
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.setAnswer(answer, roundID, timestamp.Uint64(), interval)
+}
+
+// handleAnswerUpdatedLog decodes an AnswerUpdated log of the aggregator passed in by the LogDispatcher.
+func (a *AggregatorMonitor) handleAnswerUpdatedLog(l types.Log) error {
+	update, err := a.aggregator.ParseAnswerUpdated(l)
+	if err != nil {
+		return err
+	}
+	if update.Raw.Removed {
+		// The answer of the new chain follows
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.correlateResponse(update)
+
+	var interval uint64
+	if a.round.roundID != nil && new(big.Int).Sub(update.RoundId, a.round.roundID).Cmp(big.NewInt(1)) == 0 &&
+		update.Timestamp.Uint64() > a.round.timestamp {
+		interval = update.Timestamp.Uint64() - a.round.timestamp
+	}
+	a.setAnswer(update.Current, update.RoundId, update.Timestamp.Uint64(), interval)
+
+	return nil
+}
+
+// handleNewRoundLog decodes a NewRound log of the aggregator passed in by the LogDispatcher.
+func (a *AggregatorMonitor) handleNewRoundLog(l types.Log) error {
+	round, err := a.aggregator.ParseNewRound(l)
+	if err != nil {
+		return err
+	}
+	if round.Raw.Removed {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.round.startedRound == nil || round.RoundId.Cmp(a.round.startedRound) > 0 {
+		a.round.startedRound = round.RoundId
+	}
+
+	return nil
+}
+
+// setAnswer records the answer of a round unless a later round is already known. Must be called with the lock held.
+func (a *AggregatorMonitor) setAnswer(answer *big.Int, roundID *big.Int, timestamp uint64, interval uint64) {
+	if a.round.roundID != nil && roundID.Cmp(a.round.roundID) <= 0 {
+		return
+	}
+
+	a.round.answer = answer
+	a.round.roundID = roundID
+	a.round.timestamp = timestamp
+	if interval > 0 {
+		// Keep the last known interval if rounds were skipped
+		a.round.interval = interval
+	}
+	if a.round.startedRound == nil || roundID.Cmp(a.round.startedRound) > 0 {
+		a.round.startedRound = roundID
+	}
+}
+
+// latestRound returns a copy of the round state. The round ID is nil if no answer is known yet.
+func (a *AggregatorMonitor) latestRound() roundState {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.round
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxHeadLag is the default number of blocks an endpoint may lag behind the best endpoint
+	DefaultMaxHeadLag = 5
+
+	rpcHealthCheckInterval = 10 * time.Second
+)
+
+var errFailover = errors.New("rpc endpoint failed over")
+
+type (
+	// EthClient is the subset of the ethclient API used by the monitors. It is implemented by *RPCPool.
+	EthClient interface {
+		bind.ContractBackend
+		ethereum.ChainReader
+		ethereum.TransactionReader
+
+		BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+		NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+		ChainID(ctx context.Context) (*big.Int, error)
+		// CallContext performs a raw JSON-RPC call for results the ethclient API can't decode
+		CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+		// EthSubscribe performs a raw eth_subscribe for notifications the ethclient API can't decode
+		EthSubscribe(ctx context.Context, channel interface{}, args ...interface{}) (ethereum.Subscription, error)
+	}
+
+	// RPCPool distributes calls to the active endpoint out of a list of RPC endpoints. Endpoints are health checked
+	// periodically. If the active endpoint goes down, lags behind or one of its subscriptions fails, the pool fails
+	// over to the first healthy endpoint and terminates all subscriptions on the old one so they are re-established.
+	RPCPool struct {
+		endpoints  []*rpcEndpoint
+		maxHeadLag uint64
+
+		active *rpcEndpoint
+		subs   map[*poolSubscription]*rpcEndpoint
+		lock   sync.Mutex
+
+		upGauge      *prometheus.GaugeVec
+		activeGauge  *prometheus.GaugeVec
+		headLagGauge *prometheus.GaugeVec
+		errorCounter *prometheus.CounterVec
+	}
+
+	rpcEndpoint struct {
+		url     string
+		label   string
+		rpc     *rpc.Client
+		client  *ethclient.Client
+		healthy bool
+	}
+
+	// poolSubscription wraps a subscription of an endpoint so the pool can terminate it on failover.
+	poolSubscription struct {
+		sub  ethereum.Subscription
+		err  chan error
+		quit chan struct{}
+		once sync.Once
+	}
+)
+
+// NewRPCPool dials all endpoints. The first endpoint that can be dialed becomes the active one. The metrics of
+// the pool are registered with registerer.
+func NewRPCPool(urls []string, maxHeadLag uint64, registerer prometheus.Registerer) (*RPCPool, error) {
+	p := &RPCPool{
+		maxHeadLag: maxHeadLag,
+		subs:       map[*poolSubscription]*rpcEndpoint{},
+		upGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rpc_up",
+			Help: "Whether the RPC endpoint passed the last health check",
+		}, []string{"endpoint"}),
+		activeGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rpc_active",
+			Help: "Whether the RPC endpoint is currently used",
+		}, []string{"endpoint"}),
+		headLagGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rpc_head_lag",
+			Help: "Number of blocks the RPC endpoint lags behind the best endpoint",
+		}, []string{"endpoint"}),
+		errorCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpc_errors_total",
+			Help: "Number of failed calls to the RPC endpoint",
+		}, []string{"endpoint"}),
+	}
+
+	registerer.MustRegister(p.upGauge)
+	registerer.MustRegister(p.activeGauge)
+	registerer.MustRegister(p.headLagGauge)
+	registerer.MustRegister(p.errorCounter)
+
+	for _, u := range urls {
+		e := &rpcEndpoint{url: u, label: endpointLabel(u)}
+		p.endpoints = append(p.endpoints, e)
+
+		c, err := dial(e.url)
+		if err != nil {
+			zap.L().Warn("failed to dial rpc endpoint", zap.Error(err), zap.String("endpoint", e.label))
+			p.upGauge.WithLabelValues(e.label).Set(0)
+			continue
+		}
+		e.rpc, e.client = c, ethclient.NewClient(c)
+		e.healthy = true
+		p.upGauge.WithLabelValues(e.label).Set(1)
+		if p.active == nil {
+			p.active = e
+			p.activeGauge.WithLabelValues(e.label).Set(1)
+		} else {
+			p.activeGauge.WithLabelValues(e.label).Set(0)
+		}
+	}
+	if p.active == nil {
+		return nil, fmt.Errorf("failed to dial any rpc endpoint")
+	}
+
+	return p, nil
+}
+
+// endpointLabel strips the path and credentials from an endpoint URL since they often contain API keys.
+func endpointLabel(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+func dial(rawURL string) (*rpc.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return rpc.DialContext(ctx, rawURL)
+}
+
+func (p *RPCPool) Start() {
+	go p.healthRoutine()
+}
+
+func (p *RPCPool) healthRoutine() {
+	ticker := time.NewTicker(rpcHealthCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.checkHealth()
+	}
+}
+
+// checkHealth fetches the head of every endpoint and fails over if the active endpoint is unhealthy.
+func (p *RPCPool) checkHealth() {
+	heads := make([]uint64, len(p.endpoints))
+	up := make([]bool, len(p.endpoints))
+
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		wg.Add(1)
+		go func(i int, e *rpcEndpoint) {
+			defer wg.Done()
+
+			p.lock.Lock()
+			client := e.client
+			p.lock.Unlock()
+			if client == nil {
+				c, err := dial(e.url)
+				if err != nil {
+					zap.L().Debug("failed to dial rpc endpoint", zap.Error(err), zap.String("endpoint", e.label))
+					return
+				}
+				client = ethclient.NewClient(c)
+				p.lock.Lock()
+				e.rpc, e.client = c, client
+				p.lock.Unlock()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			header, err := client.HeaderByNumber(ctx, nil)
+			if err != nil {
+				p.errorCounter.WithLabelValues(e.label).Inc()
+				zap.L().Warn("rpc endpoint health check failed", zap.Error(err), zap.String("endpoint", e.label))
+				return
+			}
+			heads[i], up[i] = header.Number.Uint64(), true
+		}(i, e)
+	}
+	wg.Wait()
+
+	var best uint64
+	for i := range p.endpoints {
+		if up[i] && heads[i] > best {
+			best = heads[i]
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, e := range p.endpoints {
+		e.healthy = up[i] && best-heads[i] <= p.maxHeadLag
+		if up[i] {
+			p.upGauge.WithLabelValues(e.label).Set(1)
+			p.headLagGauge.WithLabelValues(e.label).Set(float64(best - heads[i]))
+		} else {
+			p.upGauge.WithLabelValues(e.label).Set(0)
+		}
+	}
+
+	if !p.active.healthy {
+		p.failover()
+	}
+}
+
+// markDown marks an endpoint as unhealthy after one of its subscriptions failed.
+func (p *RPCPool) markDown(e *rpcEndpoint, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	zap.L().Warn("rpc endpoint subscription failed", zap.Error(err), zap.String("endpoint", e.label))
+	p.errorCounter.WithLabelValues(e.label).Inc()
+	e.healthy = false
+	if p.active == e {
+		p.failover()
+	}
+}
+
+// failover switches to the first healthy endpoint. The lock must be held.
+func (p *RPCPool) failover() {
+	var next *rpcEndpoint
+	for _, e := range p.endpoints {
+		if e != p.active && e.healthy && e.client != nil {
+			next = e
+			break
+		}
+	}
+	if next == nil {
+		zap.L().Error("no healthy rpc endpoint to fail over to", zap.String("endpoint", p.active.label))
+		return
+	}
+
+	zap.L().Warn("failing over rpc endpoint", zap.String("from", p.active.label), zap.String("to", next.label))
+	p.activeGauge.WithLabelValues(p.active.label).Set(0)
+	p.activeGauge.WithLabelValues(next.label).Set(1)
+
+	old := p.active
+	p.active = next
+	for sub, e := range p.subs {
+		if e == old {
+			delete(p.subs, sub)
+			go sub.fail(errFailover)
+		}
+	}
+}
+
+func (p *RPCPool) current() *rpcEndpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.active
+}
+
+// observe counts failed calls. Missing results aren't errors of the endpoint.
+func (p *RPCPool) observe(e *rpcEndpoint, err error) {
+	if err != nil && err != ethereum.NotFound {
+		p.errorCounter.WithLabelValues(e.label).Inc()
+	}
+}
+
+// wrap registers a subscription of an endpoint so it can be terminated on failover.
+func (p *RPCPool) wrap(e *rpcEndpoint, sub ethereum.Subscription) ethereum.Subscription {
+	s := &poolSubscription{
+		sub:  sub,
+		err:  make(chan error, 1),
+		quit: make(chan struct{}),
+	}
+
+	p.lock.Lock()
+	p.subs[s] = e
+	p.lock.Unlock()
+
+	go func() {
+		defer func() {
+			p.lock.Lock()
+			delete(p.subs, s)
+			p.lock.Unlock()
+		}()
+
+		select {
+		case err, ok := <-sub.Err():
+			if ok && err != nil {
+				p.markDown(e, err)
+			}
+			s.fail(err)
+		case <-s.quit:
+		}
+	}()
+
+	return s
+}
+
+func (s *poolSubscription) Err() <-chan error {
+	return s.err
+}
+
+func (s *poolSubscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.quit)
+		close(s.err)
+	})
+	s.sub.Unsubscribe()
+}
+
+func (s *poolSubscription) fail(err error) {
+	s.once.Do(func() {
+		if err != nil {
+			s.err <- err
+		}
+		close(s.err)
+		close(s.quit)
+	})
+	s.sub.Unsubscribe()
+}
+
+func (p *RPCPool) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	e := p.current()
+	sub, err := e.client.SubscribeNewHead(ctx, ch)
+	p.observe(e, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.wrap(e, sub), nil
+}
+
+func (p *RPCPool) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	e := p.current()
+	sub, err := e.client.SubscribeFilterLogs(ctx, q, ch)
+	p.observe(e, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.wrap(e, sub), nil
+}
+
+func (p *RPCPool) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	e := p.current()
+	logs, err := e.client.FilterLogs(ctx, q)
+	p.observe(e, err)
+	return logs, err
+}
+
+func (p *RPCPool) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	e := p.current()
+	code, err := e.client.CodeAt(ctx, contract, blockNumber)
+	p.observe(e, err)
+	return code, err
+}
+
+func (p *RPCPool) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	e := p.current()
+	res, err := e.client.CallContract(ctx, call, blockNumber)
+	p.observe(e, err)
+	return res, err
+}
+
+func (p *RPCPool) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	e := p.current()
+	code, err := e.client.PendingCodeAt(ctx, account)
+	p.observe(e, err)
+	return code, err
+}
+
+func (p *RPCPool) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	e := p.current()
+	nonce, err := e.client.PendingNonceAt(ctx, account)
+	p.observe(e, err)
+	return nonce, err
+}
+
+func (p *RPCPool) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	e := p.current()
+	price, err := e.client.SuggestGasPrice(ctx)
+	p.observe(e, err)
+	return price, err
+}
+
+func (p *RPCPool) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	e := p.current()
+	gas, err := e.client.EstimateGas(ctx, call)
+	p.observe(e, err)
+	return gas, err
+}
+
+func (p *RPCPool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	e := p.current()
+	err := e.client.SendTransaction(ctx, tx)
+	p.observe(e, err)
+	return err
+}
+
+func (p *RPCPool) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	e := p.current()
+	block, err := e.client.BlockByHash(ctx, hash)
+	p.observe(e, err)
+	return block, err
+}
+
+func (p *RPCPool) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	e := p.current()
+	block, err := e.client.BlockByNumber(ctx, number)
+	p.observe(e, err)
+	return block, err
+}
+
+func (p *RPCPool) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	e := p.current()
+	header, err := e.client.HeaderByHash(ctx, hash)
+	p.observe(e, err)
+	return header, err
+}
+
+func (p *RPCPool) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	e := p.current()
+	header, err := e.client.HeaderByNumber(ctx, number)
+	p.observe(e, err)
+	return header, err
+}
+
+func (p *RPCPool) TransactionCount(ctx context.Context, blockHash common.Hash) (uint, error) {
+	e := p.current()
+	count, err := e.client.TransactionCount(ctx, blockHash)
+	p.observe(e, err)
+	return count, err
+}
+
+func (p *RPCPool) TransactionInBlock(ctx context.Context, blockHash common.Hash, index uint) (*types.Transaction, error) {
+	e := p.current()
+	tx, err := e.client.TransactionInBlock(ctx, blockHash, index)
+	p.observe(e, err)
+	return tx, err
+}
+
+func (p *RPCPool) TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error) {
+	e := p.current()
+	tx, isPending, err := e.client.TransactionByHash(ctx, txHash)
+	p.observe(e, err)
+	return tx, isPending, err
+}
+
+func (p *RPCPool) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	e := p.current()
+	receipt, err := e.client.TransactionReceipt(ctx, txHash)
+	p.observe(e, err)
+	return receipt, err
+}
+
+func (p *RPCPool) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	e := p.current()
+	balance, err := e.client.BalanceAt(ctx, account, blockNumber)
+	p.observe(e, err)
+	return balance, err
+}
+
+func (p *RPCPool) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	e := p.current()
+	nonce, err := e.client.NonceAt(ctx, account, blockNumber)
+	p.observe(e, err)
+	return nonce, err
+}
+
+func (p *RPCPool) ChainID(ctx context.Context) (*big.Int, error) {
+	e := p.current()
+	id, err := e.client.ChainID(ctx)
+	p.observe(e, err)
+	return id, err
+}
+
+func (p *RPCPool) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	e := p.current()
+	err := e.rpc.CallContext(ctx, result, method, args...)
+	p.observe(e, err)
+	return err
+}
+
+func (p *RPCPool) EthSubscribe(ctx context.Context, channel interface{}, args ...interface{}) (ethereum.Subscription, error) {
+	e := p.current()
+	sub, err := e.rpc.EthSubscribe(ctx, channel, args...)
+	p.observe(e, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.wrap(e, sub), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// minRunwaySamples is the time an estimator needs to observe spending before it estimates a runway
+	minRunwaySamples = 10 * time.Minute
+)
+
+var (
+	// runwayWindows are the sliding windows the burn rate is estimated over
+	runwayWindows = []struct {
+		name   string
+		length time.Duration
+	}{
+		{"1h", time.Hour},
+		{"1d", 24 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+	}
+)
+
+type (
+	// SpendEstimator tracks the ETH spent by a node address in per-minute buckets to estimate how long its
+	// balance lasts.
+	SpendEstimator struct {
+		// spent is the ETH spent per minute
+		spent map[int64]float64
+		// fulfillments and fulfillmentCosts are the number and ETH costs of fulfillments per minute
+		fulfillments     map[int64]float64
+		fulfillmentCosts map[int64]float64
+
+		// balance is the last observed balance in ETH
+		balance float64
+		started time.Time
+		lock    sync.Mutex
+	}
+)
+
+func NewSpendEstimator() *SpendEstimator {
+	return &SpendEstimator{
+		spent:            map[int64]float64{},
+		fulfillments:     map[int64]float64{},
+		fulfillmentCosts: map[int64]float64{},
+	}
+}
+
+// ObserveBalance records the current balance. Decreases since the last observation are counted as spent,
+// increases are top-ups and ignored.
+func (e *SpendEstimator) ObserveBalance(balance float64, now time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.started.IsZero() {
+		e.started = now
+	} else if balance < e.balance {
+		e.spent[now.Unix()/60] += e.balance - balance
+	}
+	e.balance = balance
+	e.prune(now)
+}
+
+// ObserveFulfillment records the ETH cost of a fulfillment.
+func (e *SpendEstimator) ObserveFulfillment(cost float64, now time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.fulfillments[now.Unix()/60]++
+	e.fulfillmentCosts[now.Unix()/60] += cost
+}
+
+// Runway estimates the number of seconds and fulfillments the last observed balance lasts at the spending of the
+// given window. The estimates are +Inf if nothing was spent. ok is false if there isn't enough data yet.
+func (e *SpendEstimator) Runway(window time.Duration, now time.Time) (seconds float64, fulfillments float64, ok bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	observed := now.Sub(e.started)
+	if e.started.IsZero() || observed < minRunwaySamples {
+		return 0, 0, false
+	}
+	if observed > window {
+		observed = window
+	}
+
+	from := now.Add(-observed).Unix() / 60
+	var spent, count, costs float64
+	for minute := from; minute <= now.Unix()/60; minute++ {
+		spent += e.spent[minute]
+		count += e.fulfillments[minute]
+		costs += e.fulfillmentCosts[minute]
+	}
+
+	seconds, fulfillments = math.Inf(1), math.Inf(1)
+	if spent > 0 {
+		seconds = e.balance / (spent / observed.Seconds())
+	}
+	if costs > 0 {
+		fulfillments = e.balance / (costs / count)
+	}
+
+	return seconds, fulfillments, true
+}
+
+// prune removes the buckets that are older than the longest window. Must be called with the lock held.
+func (e *SpendEstimator) prune(now time.Time) {
+	oldest := now.Add(-runwayWindows[len(runwayWindows)-1].length).Unix() / 60
+	for _, buckets := range []map[int64]float64{e.spent, e.fulfillments, e.fulfillmentCosts} {
+		for minute := range buckets {
+			if minute < oldest {
+				delete(buckets, minute)
+			}
+		}
+	}
+}
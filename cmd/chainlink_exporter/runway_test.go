@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSpendEstimatorRunway(t *testing.T) {
+	type sample struct {
+		at    time.Duration
+		value float64
+	}
+	tests := []struct {
+		name         string
+		balances     []sample
+		fulfillments []sample
+		window       time.Duration
+		at           time.Duration
+		want         string
+	}{
+		{name: "nothing observed", window: time.Hour, at: time.Hour, want: "0 0 false"},
+		{name: "not enough data", balances: []sample{{0, 10}, {5 * time.Minute, 9}}, window: time.Hour,
+			at: 5 * time.Minute, want: "0 0 false"},
+		{name: "nothing spent", balances: []sample{{0, 10}, {10 * time.Minute, 10}}, window: time.Hour,
+			at: 10 * time.Minute, want: "+Inf +Inf true"},
+		{name: "spent", balances: []sample{{0, 10}, {10 * time.Minute, 9}}, window: time.Hour,
+			at: 10 * time.Minute, want: "5400 +Inf true"},
+		{name: "fulfillments", balances: []sample{{0, 10}, {10 * time.Minute, 9}},
+			fulfillments: []sample{{5 * time.Minute, 0.5}, {6 * time.Minute, 0.5}}, window: time.Hour,
+			at: 10 * time.Minute, want: "5400 18 true"},
+		{name: "top-up", balances: []sample{{0, 10}, {5 * time.Minute, 9}, {10 * time.Minute, 20}},
+			window: time.Hour, at: 10 * time.Minute, want: "12000 +Inf true"},
+		{name: "spent before window", balances: []sample{{0, 10}, {5 * time.Minute, 9}, {70 * time.Minute, 9}},
+			fulfillments: []sample{{5 * time.Minute, 1}}, window: time.Hour, at: 70 * time.Minute,
+			want: "+Inf +Inf true"},
+		{name: "spent within window", balances: []sample{{0, 10}, {5 * time.Minute, 9}, {70 * time.Minute, 8}},
+			window: time.Hour, at: 70 * time.Minute, want: "28800 +Inf true"},
+		{name: "longer window", balances: []sample{{0, 10}, {5 * time.Minute, 9}, {70 * time.Minute, 8}},
+			window: 24 * time.Hour, at: 70 * time.Minute, want: "16800 +Inf true"},
+	}
+
+	start := time.Unix(1599999960, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimator := NewSpendEstimator()
+			for _, s := range tt.balances {
+				estimator.ObserveBalance(s.value, start.Add(s.at))
+			}
+			for _, s := range tt.fulfillments {
+				estimator.ObserveFulfillment(s.value, start.Add(s.at))
+			}
+
+			seconds, fulfillments, ok := estimator.Runway(tt.window, start.Add(tt.at))
+			if got := fmt.Sprintf("%.0f %.0f %t", seconds, fulfillments, ok); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"chainlink_exporter/abi"
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	ethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// scanMaxBlocks is the maximum number of blocks scanned per head. Larger gaps, e.g. after a backfill, are caught
+	// up over several heads.
+	scanMaxBlocks = 100
+)
+
+type (
+	// FulfillmentScanner detects fulfillments by scanning blocks for fulfillOracleRequest transactions
+	// that the node sent to the oracle. It is used for requesters that don't emit fulfillment events we can watch.
+	FulfillmentScanner struct {
+		client EthClient
+		oracle common.Address
+		nodes  map[common.Address]bool
+		method ethabi.Method
+
+		// cursor is the last scanned block. It is only changed with the lock held, which is held while a block is
+		// scanned. started is false until the cursor was set.
+		cursor  *atomic.Uint64
+		started bool
+		lock    sync.Mutex
+
+		// head is the latest head, notify signals that it changed
+		head   *atomic.Uint64
+		notify chan struct{}
+	}
+
+	// rpcBlock is a block with its transactions as returned by eth_getBlockByNumber. Unlike types.Block, it only
+	// decodes the fields the scanner needs, so it works for all transaction types and doesn't recover senders.
+	rpcBlock struct {
+		Number       hexutil.Uint64   `json:"number"`
+		Hash         common.Hash      `json:"hash"`
+		Timestamp    hexutil.Uint64   `json:"timestamp"`
+		Transactions []rpcTransaction `json:"transactions"`
+	}
+
+	rpcTransaction struct {
+		Hash  common.Hash     `json:"hash"`
+		From  common.Address  `json:"from"`
+		To    *common.Address `json:"to"`
+		Input hexutil.Bytes   `json:"input"`
+	}
+)
+
+func NewFulfillmentScanner(client EthClient, oracle common.Address, nodes []common.Address) (*FulfillmentScanner, error) {
+	oracleABI, err := ethabi.JSON(strings.NewReader(abi.OracleABI))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FulfillmentScanner{
+		client: client,
+		oracle: oracle,
+		nodes:  map[common.Address]bool{},
+		method: oracleABI.Methods["fulfillOracleRequest"],
+		cursor: atomic.NewUint64(0),
+		head:   atomic.NewUint64(0),
+		notify: make(chan struct{}, 1),
+	}
+	for _, node := range nodes {
+		s.nodes[node] = true
+	}
+
+	return s, nil
+}
+
+// Notify passes a new head to the scanner without blocking.
+func (s *FulfillmentScanner) Notify(head uint64) {
+	s.head.Store(head)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+		// A scan is already due
+	}
+}
+
+// Seek makes the scanner continue after the given block.
+func (s *FulfillmentScanner) Seek(height uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.cursor.Store(height)
+	s.started = true
+}
+
+// Cursor returns the last scanned block.
+func (s *FulfillmentScanner) Cursor() uint64 {
+	return s.cursor.Load()
+}
+
+// Rewind makes the scanner scan the blocks after the given block again if it already scanned them.
+func (s *FulfillmentScanner) Rewind(height uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cursor.Load() > height {
+		s.cursor.Store(height)
+	}
+}
+
+// Scan scans up to scanMaxBlocks blocks up to head that haven't been scanned yet. The found fulfillments are passed
+// to handle, then every scanned block is passed to scanned.
+func (s *FulfillmentScanner) Scan(head uint64, handle func(res *abi.AggregatorChainlinkFulfilled), scanned func(height uint64, timestamp uint64)) error {
+	s.lock.Lock()
+	if !s.started || s.cursor.Load() > head {
+		s.cursor.Store(head - 1)
+		s.started = true
+	}
+	s.lock.Unlock()
+
+	for i := 0; i < scanMaxBlocks; i++ {
+		block, err := s.scanNext(head, handle)
+		if block == nil || err != nil {
+			return err
+		}
+		scanned(uint64(block.Number), uint64(block.Timestamp))
+	}
+
+	return nil
+}
+
+// scanNext scans the block after the cursor and returns it. It returns nil if the cursor reached head. The lock is
+// held while the block is scanned so a reorg can't rewind the cursor in the meantime.
+func (s *FulfillmentScanner) scanNext(head uint64, handle func(res *abi.AggregatorChainlinkFulfilled)) (*rpcBlock, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cursor.Load() >= head {
+		return nil, nil
+	}
+	block, err := s.block(s.cursor.Load() + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range block.Transactions {
+		if tx.To == nil || *tx.To != s.oracle || !s.nodes[tx.From] || !bytes.HasPrefix(tx.Input, s.method.ID()) {
+			continue
+		}
+
+		args, err := s.method.Inputs.UnpackValues(tx.Input[len(s.method.ID()):])
+		if err != nil {
+			zap.L().Warn("failed to decode fulfillment transaction", zap.Error(err), zap.String("tx", tx.Hash.String()))
+			continue
+		}
+		requestID, ok := args[0].([32]byte)
+		if !ok {
+			zap.L().Warn("unexpected request id type", zap.String("type", fmt.Sprintf("%T", args[0])),
+				zap.String("tx", tx.Hash.String()))
+			continue
+		}
+
+		res, err := s.fulfillment(block, tx, requestID)
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			handle(res)
+		}
+	}
+	s.cursor.Store(uint64(block.Number))
+
+	return block, nil
+}
+
+// block fetches a block with its transactions.
+func (s *FulfillmentScanner) block(number uint64) (*rpcBlock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	var block *rpcBlock
+	if err := s.client.CallContext(ctx, &block, "eth_getBlockByNumber", hexutil.EncodeUint64(number), true); err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, ethereum.NotFound
+	}
+
+	return block, nil
+}
+
+// fulfillment returns the fulfillment of a fulfillOracleRequest transaction or nil if the transaction failed.
+func (s *FulfillmentScanner) fulfillment(block *rpcBlock, tx rpcTransaction, requestID [32]byte) (*abi.AggregatorChainlinkFulfilled, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	receipt, err := s.client.TransactionReceipt(ctx, tx.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, nil
+	}
+
+	return &abi.AggregatorChainlinkFulfilled{
+		Id: requestID,
+		Raw: types.Log{
+			Address:     s.oracle,
+			BlockNumber: uint64(block.Number),
+			BlockHash:   block.Hash,
+			TxHash:      tx.Hash,
+			TxIndex:     receipt.TransactionIndex,
+		},
+	}, nil
+}
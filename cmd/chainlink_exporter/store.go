@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"strings"
+)
+
+var (
+	storeHeightKey     = []byte("height")
+	storeCountersKey   = []byte("counters")
+	storeTransfersKey  = []byte("transfers_confirmed")
+	storePendingPrefix = []byte("pending/")
+	storeSeenPrefix    = []byte("seen/")
+)
+
+type (
+	// Store persists the monitor state across restarts in an embedded LevelDB.
+	Store struct {
+		db *leveldb.DB
+	}
+
+	// Checkpoint is the state of the monitor after processing a block. It is written atomically.
+	Checkpoint struct {
+		Height   uint64
+		Counters map[string][]CounterSample
+		// Pending contains the pending requests of every known aggregator
+		Pending map[common.Address][]*pendingRequest
+		// Seen contains the heights of the requests that have been seen since the last checkpoint by request ID
+		Seen map[common.Address]map[string]uint64
+		// Removed contains the request IDs that have been reverted by a reorg or pruned since the last checkpoint
+		Removed map[common.Address][]string
+		// TransfersConfirmed is the height the LINK transfers in Counters are recorded up to
+		TransfersConfirmed uint64
+	}
+
+	CounterSample struct {
+		Labels map[string]string `json:"labels"`
+		Value  float64           `json:"value"`
+	}
+)
+
+func OpenStore(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Height returns the last checkpointed height. ok is false if no checkpoint has been written yet.
+func (s *Store) Height() (height uint64, ok bool, err error) {
+	val, err := s.db.Get(storeHeightKey, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return binary.BigEndian.Uint64(val), true, nil
+}
+
+// TransfersConfirmed returns the height the persisted LINK transfers are recorded up to. It is 0 if no checkpoint
+// has been written yet.
+func (s *Store) TransfersConfirmed() (uint64, error) {
+	val, err := s.db.Get(storeTransfersKey, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(val), nil
+}
+
+func (s *Store) Counters() (map[string][]CounterSample, error) {
+	counters := map[string][]CounterSample{}
+
+	val, err := s.db.Get(storeCountersKey, nil)
+	if err == leveldb.ErrNotFound {
+		return counters, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return counters, json.Unmarshal(val, &counters)
+}
+
+func (s *Store) Pending() (map[common.Address][]*pendingRequest, error) {
+	pending := map[common.Address][]*pendingRequest{}
+
+	it := s.db.NewIterator(util.BytesPrefix(storePendingPrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		addr := common.HexToAddress(string(it.Key()[len(storePendingPrefix):]))
+
+		var reqs []*pendingRequest
+		if err := json.Unmarshal(it.Value(), &reqs); err != nil {
+			return nil, fmt.Errorf("failed to decode pending requests of %s: %w", addr.String(), err)
+		}
+		pending[addr] = reqs
+	}
+
+	return pending, it.Error()
+}
+
+// Seen returns the heights of the seen requests by aggregator and request ID.
+func (s *Store) Seen() (map[common.Address]map[string]uint64, error) {
+	seen := map[common.Address]map[string]uint64{}
+
+	it := s.db.NewIterator(util.BytesPrefix(storeSeenPrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		// Keys are of the form seen/<aggregator>/<request id>
+		parts := strings.SplitN(string(it.Key()[len(storeSeenPrefix):]), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if len(it.Value()) != 8 {
+			return nil, fmt.Errorf("invalid height of seen request %s", string(it.Key()))
+		}
+		addr := common.HexToAddress(parts[0])
+		if seen[addr] == nil {
+			seen[addr] = map[string]uint64{}
+		}
+		seen[addr][parts[1]] = binary.BigEndian.Uint64(it.Value())
+	}
+
+	return seen, it.Error()
+}
+
+func (s *Store) WriteCheckpoint(cp *Checkpoint) error {
+	batch := new(leveldb.Batch)
+
+	height := make([]byte, 8)
+	binary.BigEndian.PutUint64(height, cp.Height)
+	batch.Put(storeHeightKey, height)
+
+	transfersConfirmed := make([]byte, 8)
+	binary.BigEndian.PutUint64(transfersConfirmed, cp.TransfersConfirmed)
+	batch.Put(storeTransfersKey, transfersConfirmed)
+
+	counters, err := json.Marshal(cp.Counters)
+	if err != nil {
+		return err
+	}
+	batch.Put(storeCountersKey, counters)
+
+	for addr, reqs := range cp.Pending {
+		val, err := json.Marshal(reqs)
+		if err != nil {
+			return err
+		}
+		batch.Put([]byte(string(storePendingPrefix)+addr.String()), val)
+	}
+
+	for addr, ids := range cp.Seen {
+		for id, requested := range ids {
+			val := make([]byte, 8)
+			binary.BigEndian.PutUint64(val, requested)
+			batch.Put([]byte(string(storeSeenPrefix)+addr.String()+"/"+id), val)
+		}
+	}
+	for addr, ids := range cp.Removed {
+		for _, id := range ids {
+			batch.Delete([]byte(string(storeSeenPrefix) + addr.String() + "/" + id))
+		}
+	}
+
+	return s.db.Write(batch, nil)
+}
+
+// snapshotMetrics reads the current values of all counters or gauges in vec. Constant labels are left out since
+// they can't be passed when the metrics are restored.
+func snapshotMetrics(vec prometheus.Collector, constLabels prometheus.Labels) []CounterSample {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(metrics)
+		close(metrics)
+	}()
+
+	var samples []CounterSample
+	for metric := range metrics {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+
+		sample := CounterSample{Labels: map[string]string{}, Value: m.GetCounter().GetValue()}
+		if m.Gauge != nil {
+			sample.Value = m.GetGauge().GetValue()
+		}
+		for _, label := range m.GetLabel() {
+			if _, ok := constLabels[label.GetName()]; ok {
+				continue
+			}
+			sample.Labels[label.GetName()] = label.GetValue()
+		}
+		samples = append(samples, sample)
+	}
+
+	return samples
+}
+
+// restoreCounters adds the persisted samples to vec.
+func restoreCounters(vec *prometheus.CounterVec, samples []CounterSample) error {
+	for _, sample := range samples {
+		counter, err := vec.GetMetricWith(sample.Labels)
+		if err != nil {
+			return err
+		}
+		counter.Add(sample.Value)
+	}
+
+	return nil
+}
+
+// restoreGauges adds the persisted samples to vec.
+func restoreGauges(vec *prometheus.GaugeVec, samples []CounterSample) error {
+	for _, sample := range samples {
+		gauge, err := vec.GetMetricWith(sample.Labels)
+		if err != nil {
+			return err
+		}
+		gauge.Add(sample.Value)
+	}
+
+	return nil
+}
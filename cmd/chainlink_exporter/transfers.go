@@ -0,0 +1,104 @@
+package main
+
+import (
+	"chainlink_exporter/abi"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.uber.org/zap"
+	"math/big"
+)
+
+var (
+	// erc20TransferTopic is the topic of the ERC20 Transfer event. The LINK token emits it for every transfer, while
+	// the ERC677 Transfer event of the binding is only emitted by transferAndCall.
+	erc20TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+)
+
+// transferQueries returns the log queries for LINK transfers to and from the oracle.
+func (m *Monitor) transferQueries() []ethereum.FilterQuery {
+	oracle := common.BytesToHash(m.addr.Bytes())
+
+	return []ethereum.FilterQuery{
+		{Addresses: []common.Address{m.cfg.LinkAddress}, Topics: [][]common.Hash{{erc20TransferTopic}, nil, {oracle}}},
+		{Addresses: []common.Address{m.cfg.LinkAddress}, Topics: [][]common.Hash{{erc20TransferTopic}, {oracle}}},
+	}
+}
+
+// handleTransferLog records a LINK transfer to or from the oracle until it is confirmed.
+func (m *Monitor) handleTransferLog(l types.Log) error {
+	if len(l.Topics) != 3 || len(l.Data) < 32 {
+		return fmt.Errorf("invalid transfer log")
+	}
+	transfer := &abi.ERCTransfer{
+		From:  common.BytesToAddress(l.Topics[1].Bytes()),
+		To:    common.BytesToAddress(l.Topics[2].Bytes()),
+		Value: new(big.Int).SetBytes(l.Data[:32]),
+		Raw:   l,
+	}
+	key := logKey{tx: l.TxHash, index: l.Index}
+
+	m.transferLock.Lock()
+	defer m.transferLock.Unlock()
+
+	if l.Removed {
+		if t, ok := m.transfers[key]; ok && t.Raw.BlockHash == l.BlockHash {
+			zap.L().Info("LINK transfer reverted by reorg", zap.Uint64("height", l.BlockNumber),
+				zap.String("tx", l.TxHash.String()))
+			delete(m.transfers, key)
+		}
+		return nil
+	}
+	if l.BlockNumber <= m.transfersConfirmed {
+		// Already recorded
+		return nil
+	}
+	m.transfers[key] = transfer
+
+	return nil
+}
+
+// confirmTransfers records the LINK transfers that are buried by the configured number of confirmations.
+func (m *Monitor) confirmTransfers(height uint64) {
+	if height < m.cfg.Confirmations {
+		return
+	}
+	confirmed := height - m.cfg.Confirmations
+
+	m.transferLock.Lock()
+	defer m.transferLock.Unlock()
+
+	for key, t := range m.transfers {
+		if t.Raw.BlockNumber > confirmed {
+			continue
+		}
+		delete(m.transfers, key)
+
+		value, _ := new(big.Float).Quo(new(big.Float).SetInt(t.Value), big.NewFloat(1e18)).Float64()
+		if t.To == m.addr {
+			m.linkInCounter.WithLabelValues(t.From.String()).Add(value)
+		}
+		if t.From == m.addr {
+			zap.L().Info("LINK transferred out of the oracle", zap.Uint64("height", t.Raw.BlockNumber),
+				zap.String("recipient", t.To.String()), zap.Float64("value", value))
+			m.linkOutCounter.WithLabelValues(t.To.String()).Add(value)
+		}
+	}
+	if confirmed > m.transfersConfirmed {
+		m.transfersConfirmed = confirmed
+	}
+}
+
+// rewindTransfers forgets the unconfirmed LINK transfers after the given block.
+func (m *Monitor) rewindTransfers(ancestor uint64) {
+	m.transferLock.Lock()
+	defer m.transferLock.Unlock()
+
+	for key, t := range m.transfers {
+		if t.Raw.BlockNumber > ancestor {
+			delete(m.transfers, key)
+		}
+	}
+}
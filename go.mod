@@ -5,6 +5,9 @@ go 1.13
 require (
 	github.com/ethereum/go-ethereum v1.9.10
 	github.com/prometheus/client_golang v1.3.0
+	github.com/prometheus/client_model v0.1.0
+	github.com/syndtr/goleveldb v1.0.1-0.20190923125748-758128399b1d
 	go.uber.org/atomic v1.5.1
 	go.uber.org/zap v1.13.0
+	gopkg.in/yaml.v2 v2.2.2
 )